@@ -11,8 +11,12 @@ import (
 
 	"clash/internal/audit"
 	"clash/internal/contextinfo"
+	"clash/internal/fixtures"
+	"clash/internal/lint"
 	"clash/internal/policy"
+	"clash/internal/replay"
 	"clash/internal/runner"
+	"clash/internal/suggest"
 )
 
 var (
@@ -39,14 +43,14 @@ func newRootCmd() *cobra.Command {
 		Long:  "CLASH provides a policy-aware chokepoint for command execution across agent CLIs.",
 	}
 
-	cmd.PersistentFlags().StringVar(&flagPolicyPath, "policy", "", "path to clash.yaml (defaults to repo root if present)")
+	cmd.PersistentFlags().StringVar(&flagPolicyPath, "policy", "", "path to clash.yaml (replaces the repo and directory layers)")
 	cmd.PersistentFlags().BoolVar(&flagYes, "yes", false, "auto-approve confirmation prompts")
 	cmd.PersistentFlags().BoolVar(&flagBreakGlass, "break-glass", false, "enable controlled override flow")
 	cmd.PersistentFlags().StringVar(&flagBreakGlassReason, "break-glass-reason", "", "reason to record when using break-glass")
 
 	cmd.AddCommand(runCmd())
 	cmd.AddCommand(initCmd())
-	cmd.AddCommand(policyExplainCmd())
+	cmd.AddCommand(policyCmd())
 	cmd.AddCommand(decisionExplainCmd())
 	cmd.AddCommand(doctorCmd())
 	cmd.AddCommand(wrapperCmd("codex"))
@@ -104,31 +108,239 @@ func wrapperCmd(name string) *cobra.Command {
 	}
 }
 
+func policyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Inspect and check policy files",
+	}
+	cmd.AddCommand(policyExplainCmd())
+	cmd.AddCommand(policyLintCmd())
+	cmd.AddCommand(policyTestCmd())
+	cmd.AddCommand(policyReplayCmd())
+	cmd.AddCommand(policySuggestCmd())
+	return cmd
+}
+
 func policyExplainCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "policy explain",
+	var sources bool
+	cmd := &cobra.Command{
+		Use:   "explain",
 		Short: "Print the effective policy",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx, _ := contextinfo.Detect()
-			path := flagPolicyPath
-			if path == "" {
-				candidate := filepath.Join(ctx.RepoRoot, "clash.yaml")
-				if _, err := os.Stat(candidate); err == nil {
-					path = candidate
+			res, err := policy.Resolve(ctx.RepoRoot, ctx.Cwd, flagPolicyPath)
+			if err != nil {
+				return err
+			}
+			yamlStr, err := res.Policy.ToYAML()
+			if sources {
+				yamlStr, err = res.SourcesYAML()
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Println("# layers: default (embedded)")
+			for _, l := range res.Layers {
+				fmt.Printf("#   %s: %s\n", l.Name, l.Path)
+			}
+			for _, w := range res.Warnings {
+				fmt.Println("# warning:", w)
+			}
+			fmt.Print(yamlStr)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&sources, "sources", false, "annotate each value with the layer that set it")
+	return cmd
+}
+
+func policyLintCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lint [file...]",
+		Short: "Check policy files for unknown keys and conflicting rules",
+		Long:  "Lint the given policy files, or every layer that applies to the current directory.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var layers []policy.Layer
+			for _, path := range args {
+				if _, err := os.Stat(path); err != nil {
+					return err
 				}
+				layers = append(layers, policy.Layer{Name: policy.LayerExplicit, Path: path})
 			}
-			pol, err := policy.Load(path)
+			if len(layers) == 0 {
+				ctx, _ := contextinfo.Detect()
+				layers = policy.Layers(ctx.RepoRoot, ctx.Cwd, flagPolicyPath)
+			}
+			findings := lint.Layers(layers)
+			for _, f := range findings {
+				fmt.Println(f)
+			}
+			if lint.HasErrors(findings) {
+				return errors.New("policy lint failed")
+			}
+			if len(findings) == 0 {
+				fmt.Println("policy lint: no problems found")
+			}
+			return nil
+		},
+	}
+}
+
+func policyTestCmd() *cobra.Command {
+	var junit string
+	cmd := &cobra.Command{
+		Use:   "test [file|dir...]",
+		Short: "Check the policy against fixture files of expected decisions",
+		Long:  "Run the fixture files given, or those in .clash/tests at the repo root, against the effective policy.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, _ := contextinfo.Detect()
+			if len(args) == 0 {
+				args = []string{filepath.Join(ctx.RepoRoot, ".clash", "tests")}
+			}
+			paths, err := fixtures.Find(args)
 			if err != nil {
 				return err
 			}
-			yamlStr, err := pol.ToYAML()
+			if len(paths) == 0 {
+				return fmt.Errorf("no fixture files in %s", strings.Join(args, ", "))
+			}
+			res, err := policy.Resolve(ctx.RepoRoot, ctx.Cwd, flagPolicyPath)
 			if err != nil {
 				return err
 			}
-			fmt.Print(yamlStr)
+			var outcomes []fixtures.Outcome
+			for _, path := range paths {
+				f, err := fixtures.Load(path)
+				if err != nil {
+					return err
+				}
+				if rel, err := filepath.Rel(ctx.Cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+					f.Path = rel
+				}
+				outcomes = append(outcomes, fixtures.Run(f, res.Policy, ctx)...)
+			}
+
+			fileWidth, caseWidth := 0, 0
+			for _, o := range outcomes {
+				fileWidth = max(fileWidth, len(o.File))
+				caseWidth = max(caseWidth, len(o.Case))
+			}
+			failed := 0
+			for _, o := range outcomes {
+				status := "PASS"
+				if !o.Passed() {
+					status = "FAIL"
+					failed++
+				}
+				decision := string(o.Result.Decision)
+				if o.Result.Hard {
+					decision += " (hard)"
+				}
+				fmt.Printf("%s  %-*s  %-*s  %s\n", status, fileWidth, o.File, caseWidth, o.Case, decision)
+				for _, f := range o.Failures {
+					fmt.Printf("      %s\n", f)
+				}
+			}
+			fmt.Printf("%d passed, %d failed\n", len(outcomes)-failed, failed)
+
+			if junit != "" {
+				out, err := os.Create(junit)
+				if err != nil {
+					return err
+				}
+				if err := fixtures.WriteJUnit(out, outcomes); err != nil {
+					out.Close()
+					return err
+				}
+				if err := out.Close(); err != nil {
+					return err
+				}
+			}
+			if failed > 0 {
+				return errors.New("policy test failed")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&junit, "junit", "", "also write results as JUnit XML to this file")
+	return cmd
+}
+
+func policyReplayCmd() *cobra.Command {
+	var logPath string
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Show which logged decisions a policy would change",
+		Long:  "Re-run every command in the audit log through the policy given with --policy (or the current one) and report the decisions that would change, as Markdown.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if logPath == "" {
+				ctx, _ := contextinfo.Detect()
+				logger, err := audit.New(ctx.RepoRoot)
+				if err != nil {
+					return err
+				}
+				logPath = logger.Path()
+			}
+			entries, err := audit.Open(logPath).Entries()
+			if err != nil {
+				return err
+			}
+			rep := replay.Run(entries, func(repoRoot, cwd string) (policy.Policy, error) {
+				res, err := policy.Resolve(repoRoot, cwd, flagPolicyPath)
+				return res.Policy, err
+			})
+			rep.Policy = flagPolicyPath
+			if rep.Policy == "" {
+				rep.Policy = "current policy"
+			}
+			rep.Markdown(os.Stdout)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&logPath, "log", "", "audit log to replay (default .clash/audit.log)")
+	return cmd
+}
+
+func policySuggestCmd() *cobra.Command {
+	var logPath string
+	opts := suggest.DefaultOptions
+	cmd := &cobra.Command{
+		Use:   "suggest",
+		Short: "Suggest policy entries from the audit log",
+		Long:  "Mine the audit log for commands that are always approved or usually cancelled and for allowlist entries never used, and print a clash.yaml patch of append/remove directives.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, _ := contextinfo.Detect()
+			if logPath == "" {
+				logger, err := audit.New(ctx.RepoRoot)
+				if err != nil {
+					return err
+				}
+				logPath = logger.Path()
+			}
+			entries, err := audit.Open(logPath).Entries()
+			if err != nil {
+				return err
+			}
+			res, err := policy.Resolve(ctx.RepoRoot, ctx.Cwd, flagPolicyPath)
+			if err != nil {
+				return err
+			}
+			defaults, err := policy.Load("")
+			if err != nil {
+				return err
+			}
+			out, err := suggest.Analyze(entries, res.Policy, defaults, opts).YAML()
+			if err != nil {
+				return err
+			}
+			fmt.Print(out)
 			return nil
 		},
 	}
+	cmd.Flags().StringVar(&logPath, "log", "", "audit log to read (default .clash/audit.log)")
+	cmd.Flags().IntVar(&opts.MinCount, "min", opts.MinCount, "times a command must be seen before it is suggested")
+	cmd.Flags().IntVar(&opts.Examples, "examples", opts.Examples, "example commands listed per suggestion")
+	return cmd
 }
 
 func initCmd() *cobra.Command {
@@ -170,12 +382,20 @@ func decisionExplainCmd() *cobra.Command {
 			}
 			fmt.Printf("Decision: %s (hard=%t)\n", e.Decision, e.Hard)
 			fmt.Printf("Command: %s\n", e.Command)
+			if e.ResolvedBinary != "" {
+				fmt.Printf("Binary: %s -> %s\n", e.RequestedBinary, e.ResolvedBinary)
+			} else if e.RequestedBinary != "" {
+				fmt.Printf("Binary: %s (not found on PATH)\n", e.RequestedBinary)
+			}
 			if len(e.Signals) > 0 {
 				fmt.Printf("Signals: %s\n", strings.Join(e.Signals, ", "))
 			}
 			if len(e.Reasons) > 0 {
 				fmt.Printf("Reasons: %s\n", strings.Join(e.Reasons, ", "))
 			}
+			if len(e.Rules) > 0 {
+				fmt.Printf("Rules: %s\n", strings.Join(e.Rules, ", "))
+			}
 			if e.Preview != nil {
 				fmt.Printf("Preview: %d items", e.Preview.Count)
 				if len(e.Preview.Sample) > 0 {
@@ -186,6 +406,19 @@ func decisionExplainCmd() *cobra.Command {
 				}
 				fmt.Println()
 			}
+			if e.Threshold != nil {
+				fmt.Printf("Threshold: %s %d > %d (%s)\n", e.Threshold.Name, e.Threshold.Count, e.Threshold.Limit, e.Threshold.Action)
+			}
+			for _, sc := range e.Scripts {
+				fmt.Printf("Script: %s sha256=%s\n", sc.Path, sc.SHA256)
+				for _, f := range sc.Findings {
+					fmt.Printf("  line %d [%s] %s", f.Line, f.Decision, f.Text)
+					if len(f.Signals) > 0 {
+						fmt.Printf(" (%s)", strings.Join(f.Signals, ", "))
+					}
+					fmt.Println()
+				}
+			}
 			fmt.Printf("Outcome: %s exit=%d\n", e.Outcome, e.ExitCode)
 			if e.ApprovedBy != "" {
 				fmt.Printf("Approved by: %s\n", e.ApprovedBy)
@@ -215,17 +448,22 @@ func doctorCmd() *cobra.Command {
 			} else {
 				fmt.Println("repo root: none (using cwd)")
 			}
-			policyPath := flagPolicyPath
-			if policyPath == "" {
-				candidate := filepath.Join(ctx.RepoRoot, "clash.yaml")
-				if _, err := os.Stat(candidate); err == nil {
-					policyPath = candidate
-				}
+			res, err := policy.Resolve(ctx.RepoRoot, ctx.Cwd, flagPolicyPath)
+			if err != nil {
+				fmt.Println("policy: error:", err)
+				return nil
 			}
-			if policyPath == "" {
+			if len(res.Layers) == 0 {
 				fmt.Println("policy: using embedded default")
-			} else {
-				fmt.Println("policy:", policyPath)
+			}
+			for _, l := range res.Layers {
+				fmt.Printf("policy (%s): %s\n", l.Name, l.Path)
+			}
+			if len(res.Policy.Locked) > 0 {
+				fmt.Println("locked:", strings.Join(res.Policy.Locked, ", "))
+			}
+			for _, w := range res.Warnings {
+				fmt.Println("warning:", w)
 			}
 			return nil
 		},
@@ -16,8 +16,14 @@ type Entry struct {
 	ID               string                `json:"id"`
 	Timestamp        time.Time             `json:"timestamp"`
 	Command          string                `json:"command"`
+	// Argv is the command as run; entries written before it was recorded
+	// only have Command.
+	Argv             []string              `json:"argv,omitempty"`
+	RequestedBinary  string                `json:"requested_binary,omitempty"`
+	ResolvedBinary   string                `json:"resolved_binary,omitempty"`
 	Cwd              string                `json:"cwd"`
 	RepoRoot         string                `json:"repo_root"`
+	InRepo           bool                  `json:"in_repo"`
 	Git              contextinfo.GitSummary `json:"git"`
 	Decision         string                `json:"decision"`
 	Hard             bool                  `json:"hard"`
@@ -25,6 +31,9 @@ type Entry struct {
 	Reasons          []string              `json:"reasons"`
 	SaferAlternative string                `json:"safer_alternative"`
 	Preview          *PreviewRecord        `json:"preview,omitempty"`
+	Scripts          []ScriptRecord        `json:"scripts,omitempty"`
+	Rules            []string              `json:"rules,omitempty"`
+	Threshold        *ThresholdRecord      `json:"threshold,omitempty"`
 	ApprovedBy       string                `json:"approved_by,omitempty"`
 	BreakGlass       bool                  `json:"break_glass"`
 	BreakGlassReason string                `json:"break_glass_reason,omitempty"`
@@ -39,6 +48,32 @@ type PreviewRecord struct {
 	Sample []string `json:"sample"`
 	Note   string   `json:"note"`
 	Err    string   `json:"err,omitempty"`
+	// Truncated is set when the preview stopped counting early.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ThresholdRecord stores the preview threshold that escalated a decision.
+type ThresholdRecord struct {
+	Name   string `json:"name"`
+	Limit  int    `json:"limit"`
+	Count  int    `json:"count"`
+	Action string `json:"action"`
+}
+
+// ScriptRecord stores a script inspected before execution.
+type ScriptRecord struct {
+	Path     string          `json:"path"`
+	SHA256   string          `json:"sha256"`
+	Findings []ScriptFinding `json:"findings,omitempty"`
+}
+
+// ScriptFinding stores a script line that raised the decision.
+type ScriptFinding struct {
+	Line     int      `json:"line"`
+	Text     string   `json:"text"`
+	Decision string   `json:"decision"`
+	Signals  []string `json:"signals,omitempty"`
+	Reasons  []string `json:"reasons,omitempty"`
 }
 
 // Logger writes audit events to disk.
@@ -65,6 +100,12 @@ func New(repoRoot string) (*Logger, error) {
 	return &Logger{path: path}, nil
 }
 
+// Open returns a logger for an existing log file, e.g. to read one copied
+// from another machine.
+func Open(path string) *Logger {
+	return &Logger{path: path}
+}
+
 // Record appends an audit entry as JSONL.
 func (l *Logger) Record(entry Entry) error {
 	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
@@ -99,6 +140,30 @@ func (l *Logger) Find(id string) (Entry, error) {
 	return Entry{}, errors.New("audit id not found")
 }
 
+// Entries returns every entry in the log, oldest first. A missing log is
+// empty.
+func (l *Logger) Entries() ([]Entry, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
 // Path returns the path to the log file.
 func (l *Logger) Path() string {
 	return l.path
@@ -0,0 +1,320 @@
+package classifier
+
+import (
+	"sort"
+	"strings"
+
+	"clash/internal/policy"
+)
+
+// Operand roles and access modes used in argument specs.
+const (
+	RoleSource      = "source"
+	RoleDestination = "destination"
+	RolePath        = "path"
+	RoleArg         = "arg"
+
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// Operand is a command argument together with its meaning.
+type Operand struct {
+	Value  string
+	Role   string
+	Access string
+}
+
+// IsPath reports whether the operand names a file.
+func (o Operand) IsPath() bool {
+	return o.Role != RoleArg
+}
+
+// subcommandTools are commands whose first operand selects a subcommand.
+var subcommandTools = map[string]bool{"git": true}
+
+// builtinArgSpecs describes common commands. Entries in the policy's
+// arg_specs replace these by command.
+var builtinArgSpecs = map[string]policy.ArgSpec{
+	"rm": {
+		Aliases:  map[string]string{"-R": "-r", "--recursive": "-r", "--force": "-f", "--dir": "-d", "--verbose": "-v"},
+		Operands: []string{"path:write..."},
+	},
+	"rmdir":    {Operands: []string{"path:write..."}},
+	"touch":    {ValueFlags: []string{"-d", "--date", "-r", "--reference", "-t"}, Operands: []string{"path:write..."}},
+	"mkdir":    {ValueFlags: []string{"-m", "--mode", "--context"}, Operands: []string{"path:write..."}},
+	"truncate": {ValueFlags: []string{"-s", "--size", "-r", "--reference"}, Operands: []string{"path:write..."}},
+	"mv": {
+		Aliases:      map[string]string{"--force": "-f", "--target-directory": "-t", "--suffix": "-S"},
+		ValueFlags:   []string{"-t", "-S"},
+		Operands:     []string{"source:write...", "destination:write"},
+		FlagOperands: map[string]string{"-t": "destination:write"},
+	},
+	"cp": {
+		Aliases:      map[string]string{"-R": "-r", "--recursive": "-r", "--force": "-f", "--target-directory": "-t", "--suffix": "-S"},
+		ValueFlags:   []string{"-t", "-S"},
+		Operands:     []string{"source:read...", "destination:write"},
+		FlagOperands: map[string]string{"-t": "destination:write"},
+	},
+	"ln": {
+		Aliases:      map[string]string{"--force": "-f", "--target-directory": "-t", "--suffix": "-S"},
+		ValueFlags:   []string{"-t", "-S"},
+		Operands:     []string{"source:read...", "destination:write"},
+		FlagOperands: map[string]string{"-t": "destination:write"},
+	},
+	// chmod's -r is a mode (remove read), so recursion is canonically
+	// --recursive for the ownership and mode commands.
+	"chmod": {
+		Flags:        []string{"--recursive"},
+		Aliases:      map[string]string{"-R": "--recursive"},
+		Operands:     []string{"arg", "path:write..."},
+		DashOperands: `-[rwxXst]+`,
+	},
+	"chown": {
+		Flags:    []string{"--recursive"},
+		Aliases:  map[string]string{"-R": "--recursive"},
+		Operands: []string{"arg", "path:write..."},
+	},
+	"chgrp": {
+		Flags:    []string{"--recursive"},
+		Aliases:  map[string]string{"-R": "--recursive"},
+		Operands: []string{"arg", "path:write..."},
+	},
+	"find": {
+		Operands:     []string{"path:read..."},
+		StopAtOption: true,
+	},
+	"rsync": {
+		Aliases:    map[string]string{"--rsh": "-e", "--filter": "-f", "--temp-dir": "-T"},
+		ValueFlags: []string{"-e", "-f", "-T", "--exclude", "--include", "--exclude-from", "--include-from", "--files-from", "--log-file", "--backup-dir", "--suffix", "--timeout", "--port", "--chmod", "--password-file", "--compare-dest", "--link-dest"},
+		Operands:   []string{"source:read...", "destination:write"},
+	},
+	"scp": {
+		ValueFlags: []string{"-c", "-F", "-i", "-J", "-l", "-o", "-P", "-S"},
+		Operands:   []string{"source:read...", "destination:write"},
+	},
+	"grep": {
+		ValueFlags:   []string{"-e", "--regexp", "-f", "--file", "-m", "--max-count", "-A", "-B", "-C", "--include", "--exclude", "--exclude-dir"},
+		Operands:     []string{"arg", "path:read..."},
+		FlagOperands: map[string]string{"-e": "arg", "--regexp": "arg", "-f": "arg", "--file": "arg"},
+	},
+	"git clean": {
+		Aliases:         map[string]string{"--force": "-f", "--dry-run": "-n", "--quiet": "-q", "--interactive": "-i", "--exclude": "-e"},
+		ValueFlags:      []string{"-e"},
+		Operands:        []string{"path:write..."},
+		AfterDoubleDash: "path:write...",
+	},
+	"git reset": {
+		Flags:           []string{"--hard", "--soft", "--mixed", "--merge", "--keep", "--quiet", "--patch", "--intent-to-add", "--refresh", "--no-refresh", "--pathspec-file-nul", "--recurse-submodules", "--no-recurse-submodules"},
+		ValueFlags:      []string{"--pathspec-from-file"},
+		Operands:        []string{"arg..."},
+		AfterDoubleDash: "path:write...",
+	},
+	"git push": {
+		Aliases:    map[string]string{"--force": "-f", "--force-with-lease": "-f", "--delete": "-d", "--push-option": "-o"},
+		ValueFlags: []string{"-o", "--repo", "--receive-pack", "--exec"},
+		Operands:   []string{"arg..."},
+	},
+	"git checkout": {
+		Aliases:         map[string]string{"--force": "-f"},
+		ValueFlags:      []string{"-b", "-B", "--orphan", "--conflict", "--pathspec-from-file"},
+		Operands:        []string{"arg..."},
+		AfterDoubleDash: "path:write...",
+	},
+	"git restore": {
+		Aliases:    map[string]string{"--source": "-s"},
+		ValueFlags: []string{"-s", "--pathspec-from-file"},
+		Operands:   []string{"path:write..."},
+	},
+	"git branch": {
+		Aliases:    map[string]string{"--force": "-f", "--delete": "-d"},
+		ValueFlags: []string{"-u", "--set-upstream-to", "--contains", "--no-contains", "--merged", "--no-merged", "--points-at", "--sort", "--format"},
+		Operands:   []string{"arg..."},
+	},
+	"git rm": {
+		Aliases:  map[string]string{"--force": "-f"},
+		Operands: []string{"path:write..."},
+	},
+	"git mv": {
+		Aliases:  map[string]string{"--force": "-f"},
+		Operands: []string{"source:write...", "destination:write"},
+	},
+}
+
+// lookupArgSpec finds the spec for a normalized argv, preferring policy
+// entries over built-ins and "command subcommand" over "command". It returns
+// the arguments following the matched command words.
+func lookupArgSpec(args []string, p policy.Policy) (policy.ArgSpec, []string, bool) {
+	if len(args) > 1 {
+		key := args[0] + " " + args[1]
+		if spec, ok := findArgSpec(key, p); ok {
+			return spec, args[2:], true
+		}
+	}
+	key, rest := commandKey(args)
+	if spec, ok := findArgSpec(key, p); ok {
+		return spec, rest, true
+	}
+	if spec, ok := findArgSpec(args[0], p); ok {
+		return spec, args[1:], true
+	}
+	return policy.ArgSpec{}, rest, false
+}
+
+func findArgSpec(key string, p policy.Policy) (policy.ArgSpec, bool) {
+	for _, spec := range p.ArgSpecs {
+		if strings.EqualFold(strings.Join(strings.Fields(spec.Command), " "), key) {
+			return spec, true
+		}
+	}
+	spec, ok := builtinArgSpecs[key]
+	return spec, ok
+}
+
+// operandsFor classifies the operands of a normalized argv. Commands without
+// a spec fall back to treating every non-option argument as a written path.
+func operandsFor(args []string, p policy.Policy) []Operand {
+	spec, rest, ok := lookupArgSpec(args, p)
+	if !ok {
+		out := []Operand{}
+		for _, t := range extractTargets(args) {
+			out = append(out, Operand{Value: t, Role: RolePath, Access: AccessWrite})
+		}
+		return out
+	}
+
+	parsed := parseArgs(rest, spec)
+	out := []Operand{}
+	pattern := spec.Operands
+	flags := make([]string, 0, len(spec.FlagOperands))
+	for flag := range spec.FlagOperands {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	seen := map[string]bool{}
+	for _, flag := range flags {
+		canonical := canonicalFlag(spec, flag)
+		values := parsed.values[canonical]
+		if len(values) == 0 || seen[canonical] {
+			continue
+		}
+		seen[canonical] = true
+		role, access, _ := parseOperandPattern(spec.FlagOperands[flag])
+		for _, v := range values {
+			out = append(out, Operand{Value: v, Role: role, Access: access})
+		}
+		// A flag that supplies a role replaces the fixed positional for it
+		// (mv -t DIR makes every positional a source).
+		pattern = dropFixedRole(pattern, role)
+	}
+
+	positional := parsed.operands
+	var afterDash []string
+	if parsed.dashdash >= 0 && spec.AfterDoubleDash != "" {
+		positional = parsed.operands[:parsed.dashdash]
+		afterDash = parsed.operands[parsed.dashdash:]
+	}
+	out = append(out, matchOperands(positional, pattern)...)
+	if len(afterDash) > 0 {
+		out = append(out, matchOperands(afterDash, []string{spec.AfterDoubleDash})...)
+	}
+	return out
+}
+
+// matchOperands assigns values to pattern entries: fixed entries before the
+// variadic one are filled from the front, those after it from the back.
+func matchOperands(values []string, pattern []string) []Operand {
+	variadic := -1
+	for i, entry := range pattern {
+		if _, _, many := parseOperandPattern(entry); many {
+			variadic = i
+			break
+		}
+	}
+
+	roles := make([]string, len(values))
+	if variadic < 0 {
+		for i := range values {
+			if i < len(pattern) {
+				roles[i] = pattern[i]
+			} else {
+				roles[i] = "arg"
+			}
+		}
+	} else {
+		head, tail := pattern[:variadic], pattern[variadic+1:]
+		for i := range values {
+			switch {
+			case i < len(head):
+				roles[i] = head[i]
+			case len(values)-i <= len(tail) && len(values)-len(tail) >= len(head):
+				roles[i] = tail[len(tail)-(len(values)-i)]
+			default:
+				roles[i] = pattern[variadic]
+			}
+		}
+	}
+
+	out := make([]Operand, 0, len(values))
+	for i, v := range values {
+		role, access, _ := parseOperandPattern(roles[i])
+		out = append(out, Operand{Value: v, Role: role, Access: access})
+	}
+	return out
+}
+
+// parseOperandPattern splits "role[:access][...]".
+func parseOperandPattern(entry string) (string, string, bool) {
+	many := strings.HasSuffix(entry, "...")
+	entry = strings.TrimSuffix(entry, "...")
+	role, access := entry, ""
+	if i := strings.Index(entry, ":"); i >= 0 {
+		role, access = entry[:i], entry[i+1:]
+	}
+	if access == "" {
+		switch role {
+		case RoleSource:
+			access = AccessRead
+		case RoleDestination, RolePath:
+			access = AccessWrite
+		}
+	}
+	return role, access, many
+}
+
+func dropFixedRole(pattern []string, role string) []string {
+	out := []string{}
+	for _, entry := range pattern {
+		r, _, many := parseOperandPattern(entry)
+		if r == role && !many {
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// pathTargets returns the local file operands, optionally only written ones.
+func pathTargets(operands []Operand, writesOnly bool) []string {
+	out := []string{}
+	for _, o := range operands {
+		if !o.IsPath() || isRemoteSpec(o.Value) {
+			continue
+		}
+		if writesOnly && o.Access != AccessWrite {
+			continue
+		}
+		out = append(out, o.Value)
+	}
+	return out
+}
+
+// isRemoteSpec recognises host:path operands (scp, rsync).
+func isRemoteSpec(v string) bool {
+	colon := strings.Index(v, ":")
+	if colon <= 0 || strings.HasPrefix(v, ".") || strings.HasPrefix(v, "/") {
+		return false
+	}
+	slash := strings.Index(v, "/")
+	return slash < 0 || colon < slash
+}
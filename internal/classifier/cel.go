@@ -0,0 +1,200 @@
+package classifier
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+	"clash/internal/preview"
+)
+
+// conditionCostLimit bounds the work one rule condition may do.
+const conditionCostLimit = 100000
+
+// conditionFields are the variables a rule's when expression can use.
+var conditionFields = map[string]*cel.Type{
+	"cmd.name":          cel.StringType,
+	"cmd.subcommand":    cel.StringType,
+	"cmd.argv":          cel.ListType(cel.StringType),
+	"cmd.args":          cel.ListType(cel.StringType),
+	"cmd.flags":         cel.ListType(cel.StringType),
+	"cmd.operands":      cel.ListType(cel.StringType),
+	"cmd.targets":       cel.ListType(cel.StringType),
+	"cmd.writes":        cel.ListType(cel.StringType),
+	"ctx.cwd":           cel.StringType,
+	"ctx.repo_root":     cel.StringType,
+	"ctx.in_repo":       cel.BoolType,
+	"ctx.outside_repo":  cel.BoolType,
+	"ctx.env":           cel.MapType(cel.StringType, cel.StringType),
+	"ctx.git.changed":   cel.IntType,
+	"ctx.git.untracked": cel.IntType,
+	"ctx.git.dirty":     cel.BoolType,
+	"ctx.git.branch":    cel.StringType,
+	"preview.available": cel.BoolType,
+	"preview.count":     cel.IntType,
+	"preview.sample":    cel.ListType(cel.StringType),
+}
+
+var (
+	conditionEnvOnce sync.Once
+	conditionEnv     *cel.Env
+	conditionEnvErr  error
+
+	conditionMu       sync.Mutex
+	conditionPrograms = map[string]cel.Program{}
+)
+
+func conditionEnvironment() (*cel.Env, error) {
+	conditionEnvOnce.Do(func() {
+		var opts []cel.EnvOption
+		for name, typ := range conditionFields {
+			opts = append(opts, cel.Variable(name, typ))
+		}
+		conditionEnv, conditionEnvErr = cel.NewEnv(opts...)
+	})
+	return conditionEnv, conditionEnvErr
+}
+
+// CompileCondition type-checks a rule's when expression: it must be a CEL
+// expression of type bool over the cmd, ctx and preview fields.
+func CompileCondition(expr string) error {
+	_, err := compileCondition(expr)
+	return err
+}
+
+func compileCondition(expr string) (cel.Program, error) {
+	conditionMu.Lock()
+	defer conditionMu.Unlock()
+	if prg, ok := conditionPrograms[expr]; ok {
+		return prg, nil
+	}
+	env, err := conditionEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		if unknown := unknownFields(expr); len(unknown) > 0 {
+			return nil, fmt.Errorf("unknown field %s (known fields: %s)", strings.Join(unknown, ", "), strings.Join(conditionFieldNames(), ", "))
+		}
+		return nil, iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("condition must be a bool, not %s", ast.OutputType())
+	}
+	prg, err := env.Program(ast, cel.CostLimit(conditionCostLimit))
+	if err != nil {
+		return nil, err
+	}
+	conditionPrograms[expr] = prg
+	return prg, nil
+}
+
+var fieldRef = regexp.MustCompile(`\b(?:cmd|ctx|preview)(?:\.[A-Za-z_][A-Za-z0-9_]*)+`)
+
+// unknownFields lists the dotted references in expr that do not start with
+// a known field, so "cmd.nmae" is reported by name rather than as an
+// undeclared cmd.
+func unknownFields(expr string) []string {
+	var unknown []string
+	for _, ref := range fieldRef.FindAllString(expr, -1) {
+		known := false
+		for name := range conditionFields {
+			if ref == name || strings.HasPrefix(ref, name+".") {
+				known = true
+				break
+			}
+		}
+		if !known {
+			unknown = appendUnique(unknown, ref)
+		}
+	}
+	return unknown
+}
+
+// usesPreview reports whether a when expression reads preview fields.
+func usesPreview(expr string) bool {
+	for _, ref := range fieldRef.FindAllString(expr, -1) {
+		if strings.HasPrefix(ref, "preview.") {
+			return true
+		}
+	}
+	return false
+}
+
+func conditionFieldNames() []string {
+	names := make([]string, 0, len(conditionFields))
+	for name := range conditionFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// evalCondition evaluates a when expression for a normalized argv. A
+// condition that fails to compile or evaluate is an error, which the caller
+// treats as a match so that a broken rule fails closed.
+func evalCondition(expr string, args []string, ctx contextinfo.Info, p policy.Policy, pr *preview.Result) (bool, error) {
+	prg, err := compileCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	cmd := DescribeCommand(args, p)
+	vars := map[string]interface{}{
+		"cmd.name":          cmd.Name,
+		"cmd.subcommand":    cmd.Subcommand,
+		"cmd.argv":          cmd.Argv,
+		"cmd.args":          cmd.Args,
+		"cmd.flags":         cmd.Flags,
+		"cmd.operands":      cmd.Operands,
+		"cmd.targets":       cmd.Targets,
+		"cmd.writes":        cmd.Writes,
+		"ctx.cwd":           ctx.Cwd,
+		"ctx.repo_root":     ctx.RepoRoot,
+		"ctx.in_repo":       ctx.InRepo,
+		"ctx.outside_repo":  isOutsideRepo(cmd.Writes, ctx),
+		"ctx.env":           environMap(ctx),
+		"ctx.git.changed":   ctx.Git.Changed,
+		"ctx.git.untracked": ctx.Git.Untracked,
+		"ctx.git.dirty":     ctx.Git.Changed > 0 || ctx.Git.Untracked > 0,
+		"ctx.git.branch":    ctx.Git.Branch,
+		"preview.available": pr != nil,
+		"preview.count":     0,
+		"preview.sample":    []string{},
+	}
+	if pr != nil {
+		vars["preview.count"] = pr.Count
+		vars["preview.sample"] = append([]string{}, pr.Sample...)
+	}
+	out, _, err := prg.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := out.Value().(bool)
+	if !isBool {
+		return false, fmt.Errorf("condition returned %v, not a bool", out.Value())
+	}
+	return ok, nil
+}
+
+// environMap returns the command's environment as a map.
+func environMap(ctx contextinfo.Info) map[string]string {
+	environ := ctx.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+	env := map[string]string{}
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
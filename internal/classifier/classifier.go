@@ -2,7 +2,6 @@ package classifier
 
 import (
 	"os"
-	"path/filepath"
 	"strings"
 
 	"clash/internal/contextinfo"
@@ -27,6 +26,9 @@ type Result struct {
 	Signals          []string
 	PreviewHint      *preview.Hint
 	SaferAlternative string
+	Executable       Executable
+	Scripts          []Script
+	Rules            []string // ids of the policy rules that matched
 }
 
 // Evaluate applies the policy ladder to the requested command.
@@ -34,31 +36,153 @@ func Evaluate(args []string, ctx contextinfo.Info, p policy.Policy) Result {
 	if len(args) == 0 {
 		return Result{Decision: DecisionBlock, Hard: true, Reasons: []string{"no command provided"}}
 	}
+	return evaluateCommand(args, ctx, p, newNesting())
+}
+
+// evaluateCommand normalizes the command name and unpacks shell payloads
+// before running the ladder so that every simple command they contain is
+// classified on its own.
+func evaluateCommand(args []string, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	if nest.depth > maxDepth {
+		return Result{Decision: DecisionConfirm, Reasons: []string{"command nesting too deep to inspect"}, Signals: []string{"deeply nested command"}}
+	}
+	if *nest.evaluations >= maxEvaluations {
+		return Result{Decision: DecisionConfirm, Reasons: []string{"too many nested commands to inspect"}, Signals: []string{"evaluation budget exceeded"}}
+	}
+	*nest.evaluations++
+	res := evaluateArgv(args, ctx, p, nest)
+	if expanded, ok := expandArgs(args, ctx); ok {
+		// "$HOME" may reach the command literally (exec arrays) or expanded
+		// (a shell in between); judge both and keep the stricter.
+		res = combine([]Result{res, evaluateArgv(expanded, ctx, p, nest)})
+	}
+	return res
+}
+
+// evaluateArgv evaluates one interpretation of a command's arguments.
+func evaluateArgv(args []string, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	argv, exe, alt := normalizeCommand(args, ctx)
+	res := evaluateNormalized(argv, ctx, p, nest)
+	if alt != "" {
+		// A symlink named like one command may point at another; judge both.
+		altArgv := append([]string{alt}, argv[1:]...)
+		res = combine([]Result{res, evaluateNormalized(altArgv, ctx, p, nest)})
+	}
+	if strings.Contains(exe.Requested, "/") && exe.Resolved != "" {
+		// A script run by path (./cleanup.sh) is read like "bash cleanup.sh".
+		if lang := shebangLang(exe.Resolved); lang != "" {
+			res = combine([]Result{res, evaluateScript(lang, exe.Resolved, ctx, p, nest.deeper())})
+		}
+	}
+	res.Executable = exe
+	return res
+}
 
+// evaluateNormalized runs the built-in ladder, then the policy's rules, its
+// detector plugins and its Starlark scripts.
+func evaluateNormalized(args []string, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	res := evaluateBuiltin(args, ctx, p, nest)
+	if rule, ok := matchRule(args, ctx, p, nil); ok {
+		res = applyRule(res, rule)
+	}
+	res = applyDetectors(res, args, ctx, p)
+	return applyStarlark(res, args, ctx, p)
+}
+
+func evaluateBuiltin(args []string, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	if inListPrefix(args[0], p.BlockCommands) {
+		return Result{Decision: DecisionBlock, Hard: true, Reasons: []string{"command is in hard block list"}}
+	}
+	if w, ok := unwrapWrapper(args, ctx); ok {
+		return evaluateWrapped(args, w, p, nest)
+	}
+	if g, ok := unwrapGit(args, ctx); ok {
+		return evaluateGit(g, p, nest)
+	}
+	if payload, ok := shellPayload(args); ok {
+		return evaluateShell(payload, ctx, p, nest)
+	}
+	if args[0] == "eval" && len(args) > 1 {
+		return evaluateShell(strings.Join(args[1:], " "), ctx, p, nest)
+	}
+	if lang, code, ok := inlineCode(args); ok {
+		return evaluateCode(lang, code, ctx, p, nest)
+	}
+	if res, ok := evaluateRecipes(args, ctx, p, nest); ok {
+		return res
+	}
+	if lang, path, ok := scriptArg(args); ok {
+		return combine([]Result{evaluateSimple(args, ctx, p), evaluateScript(lang, path, ctx, p, nest.deeper())})
+	}
+	if cmds := findExecCommands(args); len(cmds) > 0 {
+		results := []Result{evaluateSimple(args, ctx, p)}
+		for _, c := range cmds {
+			results = append(results, evaluateCommand(c, ctx, p, nest.deeper()))
+		}
+		return combine(results)
+	}
+	return evaluateSimple(args, ctx, p)
+}
+
+// evaluateWrapped classifies the command run by a prefix wrapper and adds the
+// wrapper's own signals (privilege escalation, xargs-supplied arguments).
+func evaluateWrapped(args []string, w wrapped, p policy.Policy, nest nesting) Result {
+	var res Result
+	switch {
+	case w.noExec:
+		return Result{Decision: DecisionAllow, Reasons: []string{args[0] + " does not run a command"}}
+	case w.payload != "":
+		res = evaluateShell(w.payload, w.ctx, p, nest.deeper())
+	case w.interactive:
+		res = Result{Decision: DecisionConfirm, Reasons: []string{"risk signals present"}, Signals: []string{"interactive privileged shell"}}
+	case len(w.args) == 0:
+		res = evaluateSimple(args, w.ctx, p)
+	default:
+		res = evaluateCommand(w.args, w.ctx, p, nest.deeper())
+	}
+	return withSignals(res, w.signals...)
+}
+
+// withSignals adds risk signals to a result, raising ALLOW to CONFIRM.
+func withSignals(res Result, signals ...string) Result {
+	if len(signals) == 0 {
+		return res
+	}
+	res.Signals = appendUnique(res.Signals, signals...)
+	if res.Decision == DecisionAllow {
+		res.Decision = DecisionConfirm
+		res.Reasons = []string{"risk signals present"}
+	}
+	return res
+}
+
+// evaluateSimple applies the ladder to a single simple command.
+func evaluateSimple(args []string, ctx contextinfo.Info, p policy.Policy) Result {
 	cmd := args[0]
 	lowerCmd := strings.ToLower(cmd)
-	joined := strings.Join(args, " ")
-	targets := extractTargets(args)
+	operands := operandsFor(args, p)
+	targets := pathTargets(operands, false)
+	writes := pathTargets(operands, true)
 
 	// 1) Deterministic hard blocks
 	if inListPrefix(lowerCmd, p.BlockCommands) {
 		return Result{Decision: DecisionBlock, Hard: true, Reasons: []string{"command is in hard block list"}}
 	}
 
-	if isCatastrophicRm(lowerCmd, args, ctx) {
+	if isCatastrophicRm(lowerCmd, args, ctx, p) {
 		return Result{Decision: DecisionBlock, Hard: true, Reasons: []string{"catastrophic rm target"}, SaferAlternative: "narrow path or remove -rf"}
 	}
 
-	if isUnsafeGitReset(args, ctx) {
+	if isUnsafeGitReset(args, ctx, p) {
 		return Result{Decision: DecisionBlock, Hard: true, Reasons: []string{"git reset --hard with dirty tree"}, SaferAlternative: "commit or stash first"}
 	}
 
-	if isUnsafeGitClean(args) {
+	if isUnsafeGitClean(args, p) {
 		return Result{Decision: DecisionBlock, Hard: true, Reasons: []string{"git clean -fdx without dry-run"}, SaferAlternative: "git clean -ndx"}
 	}
 
 	// 2) Deterministic allow list
-	if inAllowList(args, p.AllowCommands) {
+	if matchesCommandPrefix(args, p.AllowCommands) {
 		return Result{Decision: DecisionAllow, Reasons: []string{"allowlisted read-only command"}}
 	}
 
@@ -66,19 +190,26 @@ func Evaluate(args []string, ctx contextinfo.Info, p policy.Policy) Result {
 	riskSignals := []string{}
 	previewHint := (*preview.Hint)(nil)
 
-	if isMutatingCommand(lowerCmd) {
+	if isMutatingCommand(args, p.ConfirmCommands) {
 		riskSignals = append(riskSignals, "mutating command")
 	}
 
-	if touchesProtected(targets, ctx, p.ProtectedPaths) {
+	if hit, ok := findProtected(targets, ctx, p.ProtectedPaths); ok {
 		riskSignals = append(riskSignals, "touches protected path")
+		if hit.blocks() {
+			return Result{Decision: DecisionBlock, Reasons: []string{hit.reason()}, Signals: riskSignals}
+		}
+	}
+
+	if hasUnresolvablePath(targets, ctx) {
+		riskSignals = append(riskSignals, "unresolvable path")
 	}
 
-	if hasForceFlag(args) {
+	if hasForceFlag(args, p) {
 		riskSignals = append(riskSignals, "force flag present")
 	}
 
-	if isOutsideRepo(targets, ctx) && !p.Options.AllowOutsideRepo {
+	if isOutsideRepo(writes, ctx) && !p.Options.AllowOutsideRepo {
 		riskSignals = append(riskSignals, "outside repo root")
 	}
 
@@ -96,7 +227,13 @@ func Evaluate(args []string, ctx contextinfo.Info, p policy.Policy) Result {
 	}
 
 	if lowerCmd == "rm" {
-		previewHint = &preview.Hint{Kind: preview.HintRM, Args: args, Targets: targets}
+		parsed, _ := parseCommand(args, p)
+		previewHint = &preview.Hint{Kind: preview.HintRM, Args: args, Targets: writes, Recursive: parsed.has("-r")}
+	}
+
+	if lowerCmd == "chmod" || lowerCmd == "chown" || lowerCmd == "chgrp" {
+		parsed, _ := parseCommand(args, p)
+		previewHint = &preview.Hint{Kind: preview.HintModify, Args: args, Targets: writes, Recursive: parsed.has("--recursive")}
 	}
 
 	if isGitClean(args) {
@@ -116,9 +253,12 @@ func Evaluate(args []string, ctx contextinfo.Info, p policy.Policy) Result {
 	}
 }
 
-func inAllowList(args []string, allow []string) bool {
+// matchesCommandPrefix reports whether the argv starts with one of the
+// entries, compared word by word ("git checkout" matches "git checkout -b x"
+// but not "git checkout-index").
+func matchesCommandPrefix(args []string, list []string) bool {
 	joined := strings.ToLower(strings.Join(args, " "))
-	for _, a := range allow {
+	for _, a := range list {
 		if joined == strings.ToLower(a) || strings.HasPrefix(joined, strings.ToLower(a)+" ") {
 			return true
 		}
@@ -151,40 +291,18 @@ func extractTargets(args []string) []string {
 	return targets
 }
 
-func isMutatingCommand(cmd string) bool {
-	switch cmd {
-	case "rm", "rmdir", "mv", "chmod", "chown", "truncate":
-		return true
-	case "git":
-		return true
-	}
-	return false
+// isMutatingCommand reports commands listed in the policy's confirm_commands.
+func isMutatingCommand(args []string, confirm []string) bool {
+	return matchesCommandPrefix(args, confirm)
 }
 
-func touchesProtected(targets []string, ctx contextinfo.Info, protected []string) bool {
+// hasUnresolvablePath reports targets whose real location cannot be
+// determined (symlink loops, unreadable directories); they are not silently
+// skipped by the path checks.
+func hasUnresolvablePath(targets []string, ctx contextinfo.Info) bool {
 	for _, t := range targets {
-		resolved, err := contextinfo.ResolvePath(ctx.Cwd, t)
-		if err != nil {
-			continue
-		}
-		for _, p := range protected {
-			if p == "" {
-				continue
-			}
-			candidate := p
-			if strings.HasPrefix(p, "~") {
-				h, _ := os.UserHomeDir()
-				candidate = filepath.Join(h, strings.TrimPrefix(p, "~"))
-			}
-			if strings.HasPrefix(p, "$") {
-				env := os.Getenv(strings.TrimPrefix(p, "$"))
-				if env != "" {
-					candidate = env
-				}
-			}
-			if strings.HasPrefix(resolved, candidate) {
-				return true
-			}
+		if _, err := contextinfo.ResolvePath(ctx.Cwd, t); err != nil {
+			return true
 		}
 	}
 	return false
@@ -206,7 +324,12 @@ func isOutsideRepo(targets []string, ctx contextinfo.Info) bool {
 	return false
 }
 
-func hasForceFlag(args []string) bool {
+// hasForceFlag reports force-like flags. Commands with a registered flag
+// grammar are parsed semantically; others only match the literal spellings.
+func hasForceFlag(args []string, p policy.Policy) bool {
+	if parsed, ok := parseCommand(args, p); ok {
+		return parsed.has("-f", "--force", "--hard")
+	}
 	for _, a := range args {
 		if a == "-f" || a == "--force" || a == "--hard" || a == "-rf" || a == "-fr" {
 			return true
@@ -249,48 +372,57 @@ func isFindDelete(args []string) bool {
 }
 
 func isGitClean(args []string) bool {
-	return len(args) >= 2 && args[0] == "git" && args[1] == "clean"
+	key, _ := commandKey(args)
+	return key == "git clean"
+}
+
+// HardBlockCommands are the commands with built-in hard-block rules besides
+// block_commands: catastrophic rm, git reset --hard on a dirty tree and
+// git clean -fdx.
+var HardBlockCommands = []string{"rm", "git reset", "git clean"}
+
+// builtinGrammar drops the policy's arg_specs so that the hard-block checks
+// always read HardBlockCommands with the built-in grammar.
+func builtinGrammar(p policy.Policy) policy.Policy {
+	p.ArgSpecs = nil
+	return p
 }
 
-func isUnsafeGitClean(args []string) bool {
+func isUnsafeGitClean(args []string, p policy.Policy) bool {
+	p = builtinGrammar(p)
 	if !isGitClean(args) {
 		return false
 	}
-	hasDryRun := false
-	hasTarget := false
-	for _, a := range args[2:] {
-		if a == "-n" || a == "--dry-run" {
-			hasDryRun = true
-		}
-		if !strings.HasPrefix(a, "-") {
-			hasTarget = true
-		}
-	}
-	flags := strings.Join(args, " ")
-	if strings.Contains(flags, "-fdx") && !hasDryRun && !hasTarget {
-		return true
+	parsed, _ := parseCommand(args, p)
+	if !parsed.has("-f") || !parsed.has("-d") || !parsed.has("-x") {
+		return false
 	}
-	return false
+	return !parsed.has("-n") && len(parsed.operands) == 0
 }
 
-func isUnsafeGitReset(args []string, ctx contextinfo.Info) bool {
-	if len(args) >= 3 && args[0] == "git" && args[1] == "reset" && args[2] == "--hard" {
-		if ctx.Git.Changed > 0 || ctx.Git.Untracked > 0 {
-			return true
-		}
+func isUnsafeGitReset(args []string, ctx contextinfo.Info, p policy.Policy) bool {
+	p = builtinGrammar(p)
+	key, _ := commandKey(args)
+	if key != "git reset" {
+		return false
 	}
-	return false
+	parsed, _ := parseCommand(args, p)
+	if !parsed.has("--hard") {
+		return false
+	}
+	return ctx.Git.Changed > 0 || ctx.Git.Untracked > 0
 }
 
-func isCatastrophicRm(cmd string, args []string, ctx contextinfo.Info) bool {
+func isCatastrophicRm(cmd string, args []string, ctx contextinfo.Info, p policy.Policy) bool {
+	p = builtinGrammar(p)
 	if cmd != "rm" {
 		return false
 	}
-	if !(hasFlag(args, "-r") || hasFlag(args, "-rf") || hasFlag(args, "-fr")) {
+	parsed, _ := parseCommand(args, p)
+	if !parsed.has("-r") {
 		return false
 	}
-	targets := extractTargets(args)
-	for _, t := range targets {
+	for _, t := range pathTargets(operandsFor(args, p), true) {
 		resolved, err := contextinfo.ResolvePath(ctx.Cwd, t)
 		if err != nil {
 			continue
@@ -299,7 +431,7 @@ func isCatastrophicRm(cmd string, args []string, ctx contextinfo.Info) bool {
 			return true
 		}
 		home, _ := os.UserHomeDir()
-		if resolved == home {
+		if resolved == home || resolved == ctx.Getenv("HOME") {
 			return true
 		}
 		if ctx.InRepo && !contextinfo.IsInsideRepo(ctx.RepoRoot, resolved) {
@@ -309,15 +441,6 @@ func isCatastrophicRm(cmd string, args []string, ctx contextinfo.Info) bool {
 	return false
 }
 
-func hasFlag(args []string, flag string) bool {
-	for _, a := range args {
-		if a == flag {
-			return true
-		}
-	}
-	return false
-}
-
 func suggestAlternative(cmd string, hint *preview.Hint) string {
 	switch cmd {
 	case "rm":
@@ -1,11 +1,17 @@
 package classifier
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"clash/internal/contextinfo"
 	"clash/internal/policy"
+	"clash/internal/preview"
 )
 
 func TestAllowList(t *testing.T) {
@@ -36,3 +42,1113 @@ func TestRmConfirm(t *testing.T) {
 		t.Fatalf("expected confirm, got %s", res.Decision)
 	}
 }
+
+func TestShellPayloadHardBlock(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	res := Evaluate([]string{"bash", "-c", "echo hi && rm -rf ~"}, ctx, pol)
+	if res.Decision != DecisionBlock || !res.Hard {
+		t.Fatalf("expected hard block, got %v hard=%v", res.Decision, res.Hard)
+	}
+}
+
+func TestShellPayloadStrictestWins(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	cases := map[string]DecisionType{
+		"ls | grep foo":          DecisionAllow,
+		"ls; (cd sub && rm foo)": DecisionConfirm,
+		"echo $(rm -rf /)":       DecisionBlock,
+		"ls > /etc/passwd":       DecisionConfirm,
+		"if then fi (":           DecisionConfirm,
+	}
+	for script, want := range cases {
+		res := Evaluate([]string{"sh", "-c", script}, ctx, pol)
+		if res.Decision != want {
+			t.Errorf("%q: expected %s, got %s (%v)", script, want, res.Decision, res.Reasons)
+		}
+	}
+}
+
+func TestNormalizedCommandNames(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	for _, args := range [][]string{
+		{"/bin/rm", "-rf", "/"},
+		{`\rm`, "-rf", "/"},
+		{"busybox", "rm", "-rf", "/"},
+		{"coreutils", "--coreutils-prog=rm", "-rf", "/"},
+	} {
+		res := Evaluate(args, ctx, pol)
+		if res.Decision != DecisionBlock || !res.Hard {
+			t.Errorf("%v: expected hard block, got %v hard=%v", args, res.Decision, res.Hard)
+		}
+	}
+}
+
+func TestSymlinkedBinaryJudgedByTarget(t *testing.T) {
+	tmp := t.TempDir()
+	realRm := filepath.Join(tmp, "rm")
+	if err := os.WriteFile(realRm, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(realRm, filepath.Join(tmp, "ls")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	res := Evaluate([]string{"./ls", "-r", "/"}, ctx, pol)
+	if res.Decision != DecisionBlock || !res.Hard {
+		t.Fatalf("expected hard block, got %v hard=%v", res.Decision, res.Hard)
+	}
+	if res.Executable.Requested != "./ls" || res.Executable.Resolved != realRm {
+		t.Fatalf("unexpected executable %+v", res.Executable)
+	}
+}
+
+func TestWrappersAreUnwrapped(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	for _, args := range [][]string{
+		{"sudo", "rm", "-rf", "/"},
+		{"sudo", "-u", "root", "--", "rm", "-rf", "/"},
+		{"env", "-i", "FOO=1", "rm", "-rf", "/"},
+		{"nice", "-n", "10", "nohup", "rm", "-rf", "/"},
+		{"timeout", "-k", "1s", "5s", "rm", "-rf", "/"},
+		{"xargs", "-0", "-n1", "rm", "-rf", "/"},
+		{"stdbuf", "-oL", "rm", "-rf", "/"},
+		{"su", "-c", "rm -rf /", "root"},
+		{"env", "-S", "rm -rf", "/"},
+		{"find", ".", "-exec", "rm", "-rf", "/", ";"},
+		{"find", ".", "-name", "*.tmp", "-execdir", "true", "{}", "+", "-okdir", "sudo", "rm", "-rf", "/", ";"},
+	} {
+		res := Evaluate(args, ctx, pol)
+		if res.Decision != DecisionBlock || !res.Hard {
+			t.Errorf("%v: expected hard block, got %v hard=%v", args, res.Decision, res.Hard)
+		}
+	}
+
+	res := Evaluate([]string{"sudo", "ls"}, ctx, pol)
+	if res.Decision != DecisionConfirm || !containsString(res.Signals, "privilege escalation via sudo") {
+		t.Fatalf("expected escalation confirm, got %v %v", res.Decision, res.Signals)
+	}
+	res = Evaluate([]string{"command", "-v", "rm"}, ctx, pol)
+	if res.Decision != DecisionAllow {
+		t.Fatalf("expected allow for command -v, got %v", res.Decision)
+	}
+	for args, want := range map[string]DecisionType{
+		"find . -name *.go -exec grep -l TODO {} +": DecisionAllow,
+		"find build -ok rm -r {} ;":                 DecisionConfirm,
+	} {
+		if res := Evaluate(strings.Fields(args), ctx, pol); res.Decision != want {
+			t.Errorf("%s: expected %s, got %s %v", args, want, res.Decision, res.Reasons)
+		}
+	}
+}
+
+func TestFlagSpellingsAreNormalized(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true, Git: contextinfo.GitSummary{Changed: 1}}
+	pol, _ := policy.Load("")
+	for _, args := range [][]string{
+		{"rm", "-Rf", "/"},
+		{"rm", "-r", "-f", "/"},
+		{"rm", "--recursive", "--force", "/"},
+		{"rm", "--recur", "/"},
+		{"rm", "-rfv", "/"},
+		{"rm", "-fR", "/"},
+		{"rm", "/", "-r"},
+		{"git", "clean", "-f", "-d", "-x"},
+		{"git", "clean", "-xdf"},
+		{"git", "clean", "--force", "-dx", "-e", "keep"},
+		{"git", "reset", "HEAD~1", "--hard"},
+		{"git", "reset", "--ha"},
+	} {
+		res := Evaluate(args, ctx, pol)
+		if res.Decision != DecisionBlock || !res.Hard {
+			t.Errorf("%v: expected hard block, got %v hard=%v", args, res.Decision, res.Hard)
+		}
+	}
+
+	res := Evaluate([]string{"git", "clean", "-fdxn"}, ctx, pol)
+	if res.Decision == DecisionBlock {
+		t.Fatalf("dry-run git clean should not block: %v", res.Reasons)
+	}
+}
+
+func TestOperandsFollowArgSpecs(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+
+	ops := operandsFor([]string{"mv", "-t", "dest", "a", "b"}, pol)
+	want := []Operand{
+		{Value: "dest", Role: RoleDestination, Access: AccessWrite},
+		{Value: "a", Role: RoleSource, Access: AccessWrite},
+		{Value: "b", Role: RoleSource, Access: AccessWrite},
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("unexpected operands %+v", ops)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Fatalf("operand %d: expected %+v, got %+v", i, want[i], ops[i])
+		}
+	}
+
+	if got := pathTargets(operandsFor([]string{"git", "checkout", "main"}, pol), false); len(got) != 0 {
+		t.Fatalf("git checkout ref should not be a path: %v", got)
+	}
+	if got := pathTargets(operandsFor([]string{"find", ".", "-name", "foo"}, pol), false); len(got) != 1 || got[0] != "." {
+		t.Fatalf("find should only target its start points: %v", got)
+	}
+
+	res := Evaluate([]string{"cp", "/etc/hosts", "hosts"}, ctx, pol)
+	if containsString(res.Signals, "outside repo root") {
+		t.Fatalf("reading outside the repo should not signal: %v", res.Signals)
+	}
+	res = Evaluate([]string{"cp", "hosts", "/"}, ctx, pol)
+	if !containsString(res.Signals, "outside repo root") {
+		t.Fatalf("writing outside the repo should signal: %v", res.Signals)
+	}
+
+	for _, args := range [][]string{{"chmod", "-x", "/etc/passwd"}, {"chmod", "-w", "/etc/hosts"}} {
+		res = Evaluate(args, ctx, pol)
+		if !containsString(res.Signals, "touches protected path") || !containsString(res.Signals, "outside repo root") {
+			t.Fatalf("%v: a dash mode should not hide the target: %v", args, res.Signals)
+		}
+	}
+	res = Evaluate([]string{"chmod", "-r", "file"}, ctx, pol)
+	if res.PreviewHint == nil || res.PreviewHint.Recursive || len(res.PreviewHint.Targets) != 1 || res.PreviewHint.Targets[0] != "file" {
+		t.Fatalf("chmod -r is a mode, not recursion: %+v", res.PreviewHint)
+	}
+	res = Evaluate([]string{"chmod", "--recur", "755", "dir"}, ctx, pol)
+	if res.PreviewHint == nil || !res.PreviewHint.Recursive {
+		t.Fatalf("chmod --recursive should preview recursively: %+v", res.PreviewHint)
+	}
+
+	pol.ArgSpecs = []policy.ArgSpec{{Command: "deploy", ValueFlags: []string{"--config"}, Operands: []string{"arg..."}}}
+	res = Evaluate([]string{"deploy", "--config", "/etc/deploy.yaml", "prod"}, ctx, pol)
+	if res.Decision != DecisionAllow {
+		t.Fatalf("expected allow with policy arg spec, got %v %v", res.Decision, res.Signals)
+	}
+
+	// Policy specs never change how the hard-block checks read their commands.
+	pol.ArgSpecs = []policy.ArgSpec{
+		{Command: "rm", Operands: []string{"arg..."}},
+		{Command: "git clean", ValueFlags: []string{"-f", "-d", "-x"}},
+		{Command: "git reset", Operands: []string{"arg..."}, Flags: []string{"--hardly"}},
+	}
+	ctx.Git.Changed = 1
+	for _, args := range [][]string{{"rm", "-rf", "/"}, {"git", "clean", "-fdx"}, {"git", "reset", "--hard"}} {
+		if res := Evaluate(args, ctx, pol); res.Decision != DecisionBlock || !res.Hard {
+			t.Errorf("%v: expected hard block despite the policy arg spec, got %s %v", args, res.Decision, res.Reasons)
+		}
+	}
+}
+
+func TestInterpreterOneLiners(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	cases := []struct {
+		args []string
+		want DecisionType
+		hard bool
+	}{
+		{[]string{"python3", "-c", "import shutil; shutil.rmtree('/')"}, DecisionBlock, true},
+		{[]string{"python", "-c", "import os, shutil; shutil.rmtree(os.path.expanduser('~'))"}, DecisionBlock, true},
+		{[]string{"python3", "-c", "import shutil; shutil.rmtree('build')"}, DecisionConfirm, false},
+		{[]string{"python3", "-c", "import os; os.system('rm -rf /')"}, DecisionBlock, true},
+		{[]string{"python3", "-c", "import subprocess; subprocess.run(['rm', '-rf', '/'])"}, DecisionBlock, true},
+		{[]string{"python3", "-c", "import requests; requests.get('https://example.com')"}, DecisionConfirm, false},
+		{[]string{"python3", "-c", "print(1)"}, DecisionAllow, false},
+		{[]string{"node", "-e", "require('fs').rmSync('/', {recursive: true})"}, DecisionBlock, true},
+		{[]string{"node", "-e", "require('child_process').execSync('rm -rf ~')"}, DecisionBlock, true},
+		{[]string{"perl", "-e", "unlink 'foo.txt'"}, DecisionConfirm, false},
+		{[]string{"perl", "-e", "unlink;"}, DecisionConfirm, false},
+		{[]string{"python3", "-c", "import shutil; shutil.rmtree()"}, DecisionConfirm, false},
+		{[]string{"perl", "-le", "system('rm', '-rf', '/')"}, DecisionBlock, true},
+		{[]string{"ruby", "-e", "FileUtils.rm_rf(Dir.home)"}, DecisionBlock, true},
+		{[]string{"ruby", "-e", "`rm -rf /`"}, DecisionBlock, true},
+		{[]string{"php", "-r", "shell_exec('ls');"}, DecisionConfirm, false},
+	}
+	for _, c := range cases {
+		res := Evaluate(c.args, ctx, pol)
+		if res.Decision != c.want || res.Hard != c.hard {
+			t.Errorf("%v: expected %s hard=%v, got %s hard=%v (%v %v)", c.args, c.want, c.hard, res.Decision, res.Hard, res.Reasons, res.Signals)
+		}
+	}
+}
+
+func TestScriptFilesAreScanned(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	if err := os.WriteFile(filepath.Join(tmp, "cleanup.sh"), []byte("#!/bin/sh\necho cleaning\nrm -rf ~\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "cleanup.py"), []byte("import shutil\n\nshutil.rmtree('build')\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"bash", "cleanup.sh"}, {"sh", "-e", "./cleanup.sh"}, {"source", "cleanup.sh"}, {"./cleanup.sh"}} {
+		res := Evaluate(args, ctx, pol)
+		if res.Decision != DecisionBlock || !res.Hard {
+			t.Fatalf("%v: expected hard block, got %s %v", args, res.Decision, res.Reasons)
+		}
+		if len(res.Scripts) != 1 || len(res.Scripts[0].SHA256) != 64 {
+			t.Fatalf("%v: expected script record, got %+v", args, res.Scripts)
+		}
+		if f := res.Scripts[0].Findings; len(f) != 1 || f[0].Line != 3 || f[0].Text != "rm -rf ~" {
+			t.Fatalf("%v: unexpected findings %+v", args, f)
+		}
+	}
+
+	res := Evaluate([]string{"python3", "-u", "cleanup.py"}, ctx, pol)
+	if res.Decision != DecisionConfirm || len(res.Scripts) != 1 || res.Scripts[0].Findings[0].Line != 3 {
+		t.Fatalf("expected confirm with finding on line 3, got %s %+v", res.Decision, res.Scripts)
+	}
+
+	res = Evaluate([]string{"bash", "missing.sh"}, ctx, pol)
+	if res.Decision != DecisionConfirm || !containsString(res.Signals, "script not found: missing.sh") {
+		t.Fatalf("expected confirm for missing script, got %s %v", res.Decision, res.Signals)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmp, "big.sh"), []byte(strings.Repeat("#", maxScriptSize+1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for script, signal := range map[string]string{
+		"big.sh":    "script too large to inspect: big.sh",
+		"/dev/zero": "script is not a regular file: /dev/zero",
+		".":         "script is not a regular file: .",
+	} {
+		res = Evaluate([]string{"bash", script}, ctx, pol)
+		if res.Decision != DecisionConfirm || !containsString(res.Signals, signal) {
+			t.Fatalf("%s: expected confirm with %q, got %s %v", script, signal, res.Decision, res.Signals)
+		}
+	}
+}
+
+func TestScriptCyclesAndFanOut(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	write := func(name, line string) {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(strings.Repeat(line+"\n", 40)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("self.sh", "source ./self.sh")
+	write("ping.sh", ". ./pong.sh")
+	write("pong.sh", "bash ./ping.sh")
+	write("a.sh", "source ./b.sh")
+	write("b.sh", "source ./c.sh")
+	write("c.sh", "source ./d.sh")
+	write("d.sh", "echo hi")
+
+	for script, signal := range map[string]string{
+		"self.sh": "script sources itself: ./self.sh",
+		"ping.sh": "script sources itself: ./ping.sh",
+		"a.sh":    "evaluation budget exceeded",
+	} {
+		start := time.Now()
+		res := Evaluate([]string{"bash", script}, ctx, pol)
+		if res.Decision != DecisionConfirm || !containsString(res.Signals, signal) {
+			t.Errorf("%s: expected confirm with %q, got %s %v", script, signal, res.Decision, res.Signals)
+		}
+		if d := time.Since(start); d > 10*time.Second {
+			t.Errorf("%s: evaluation took %s", script, d)
+		}
+	}
+	if res := Evaluate([]string{"bash", "c.sh"}, ctx, pol); res.Decision != DecisionAllow {
+		t.Errorf("expected a script sourcing another twice to be allowed, got %s %v", res.Decision, res.Signals)
+	}
+}
+
+func TestTaskRunnerRecipes(t *testing.T) {
+	tmp := t.TempDir()
+	sub := filepath.Join(tmp, "web")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"Makefile":         "OUT := build\n\nall: test\n\ntest:\n\tgo test ./...\n\nclean: tidy\n\t@rm -rf $(OUT)\n\ntidy:\n\trm -rf ~\n\npurge:\n\t$(RM) -r ~\n\nscrub:\n\t$(NUKE) -r build\n",
+		"web/package.json": `{"scripts": {"build": "tsc", "prereset": "rm -rf /", "reset": "echo reset"}}`,
+		"justfile":         "default: build\n\nbuild:\n    echo building\n\nnuke target='x': build\n    rm -rf {{target}}\n",
+		"Taskfile.yml":     "version: '3'\ntasks:\n  wipe:\n    deps: [prep]\n    cmds:\n      - echo wiping\n      - task: purge\n  prep: echo prep\n  purge:\n    cmds:\n      - cmd: rm -rf ~\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+
+	cases := []struct {
+		args   []string
+		cwd    string
+		want   DecisionType
+		hard   bool
+		reason string
+	}{
+		{[]string{"make"}, tmp, DecisionAllow, false, ""},
+		{[]string{"make", "-j", "4", "clean"}, tmp, DecisionBlock, true, "recipe tidy (Makefile:12): rm -rf ~"},
+		{[]string{"make", "-n", "clean"}, tmp, DecisionAllow, false, ""},
+		{[]string{"make", "purge"}, tmp, DecisionBlock, true, "recipe purge (Makefile:15): rm -f -r ~"},
+		{[]string{"make", "scrub"}, tmp, DecisionConfirm, false, "recipe scrub (Makefile:18): -r build"},
+		{[]string{"make", "scrub", "NUKE=echo"}, tmp, DecisionAllow, false, ""},
+		{[]string{"npm", "run", "build"}, sub, DecisionConfirm, false, ""},
+		{[]string{"npm", "run", "reset"}, sub, DecisionBlock, true, "recipe prereset (web/package.json:1): rm -rf /"},
+		{[]string{"just"}, tmp, DecisionAllow, false, ""},
+		{[]string{"just", "nuke", "out"}, tmp, DecisionConfirm, false, "recipe nuke (justfile:7): rm -rf {{target}}"},
+		{[]string{"task", "wipe"}, tmp, DecisionBlock, true, "recipe purge (Taskfile.yml:11): rm -rf ~"},
+	}
+	for _, c := range cases {
+		ctx.Cwd = c.cwd
+		res := Evaluate(c.args, ctx, pol)
+		if res.Decision != c.want || res.Hard != c.hard {
+			t.Errorf("%v: expected %s hard=%v, got %s hard=%v (%v %v)", c.args, c.want, c.hard, res.Decision, res.Hard, res.Reasons, res.Signals)
+			continue
+		}
+		if c.reason != "" && !containsString(res.Reasons, c.reason) {
+			t.Errorf("%v: expected reason %q, got %v", c.args, c.reason, res.Reasons)
+		}
+	}
+	ctx.Cwd = tmp
+	res := Evaluate([]string{"make", "scrub"}, ctx, pol)
+	for _, signal := range []string{"unresolved make variable: NUKE", "make variable leaves recipe without a command"} {
+		if !containsString(res.Signals, signal) {
+			t.Errorf("make scrub: expected signal %q, got %v", signal, res.Signals)
+		}
+	}
+}
+
+func TestGitGlobalOptionsAndAliases(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmp := t.TempDir()
+	other := filepath.Join(tmp, "other")
+	if err := exec.Command("git", "init", "-q", other).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "wip.txt"), []byte("wip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	aliases := map[string]string{"nuke": "!rm -rf /", "wipe": "reset --hard", "st": "status"}
+	orig := gitAlias
+	gitAlias = func(globals []string, name string) (string, bool) {
+		for i, g := range globals {
+			if g == "-c" && strings.HasPrefix(globals[i+1], "alias."+name+"=") {
+				return strings.TrimPrefix(globals[i+1], "alias."+name+"="), true
+			}
+		}
+		v, ok := aliases[name]
+		return v, ok
+	}
+	defer func() { gitAlias = orig }()
+
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	cases := []struct {
+		args   []string
+		want   DecisionType
+		hard   bool
+		signal string
+	}{
+		{[]string{"git", "-C", "other", "reset", "--hard"}, DecisionBlock, true, ""},
+		{[]string{"git", "--work-tree=other", "--git-dir", "other/.git", "reset", "--hard"}, DecisionBlock, true, ""},
+		{[]string{"git", "-C", "other", "wipe"}, DecisionBlock, true, ""},
+		{[]string{"git", "nuke"}, DecisionBlock, true, ""},
+		{[]string{"git", "-c", "alias.x=!rm -rf ~", "x"}, DecisionBlock, true, ""},
+		{[]string{"git", "-c", "core.hooksPath=/tmp/x", "commit", "-m", "x"}, DecisionConfirm, false, "git config override: core.hookspath"},
+		{[]string{"git", "-c", "core.pager=cat", "log"}, DecisionAllow, false, ""},
+		{[]string{"git", "--no-pager", "st"}, DecisionAllow, false, ""},
+	}
+	for _, c := range cases {
+		res := Evaluate(c.args, ctx, pol)
+		if res.Decision != c.want || res.Hard != c.hard {
+			t.Errorf("%v: expected %s hard=%v, got %s hard=%v (%v %v)", c.args, c.want, c.hard, res.Decision, res.Hard, res.Reasons, res.Signals)
+			continue
+		}
+		if c.signal != "" && !containsString(res.Signals, c.signal) {
+			t.Errorf("%v: expected signal %q, got %v", c.args, c.signal, res.Signals)
+		}
+	}
+}
+
+func TestMissingTargetsAreResolved(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", filepath.Join(tmp, "home"))
+	repo := filepath.Join(tmp, "repo")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/share", filepath.Join(repo, "share")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("loop-b", filepath.Join(repo, "loop-a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("loop-a", filepath.Join(repo, "loop-b")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: repo, RepoRoot: repo, InRepo: true}
+	pol, _ := policy.Load("")
+
+	cases := []struct {
+		args    []string
+		signals []string
+	}{
+		{[]string{"mv", "a", "~/.ssh/authorized_keys"}, []string{"touches protected path", "outside repo root"}},
+		{[]string{"cp", "x", "/etc/newfile"}, []string{"touches protected path", "outside repo root"}},
+		{[]string{"touch", "share/../newfile"}, []string{"touches protected path", "outside repo root"}},
+		{[]string{"rm", "loop-a/x"}, []string{"unresolvable path"}},
+	}
+	for _, c := range cases {
+		res := Evaluate(c.args, ctx, pol)
+		for _, s := range c.signals {
+			if !containsString(res.Signals, s) {
+				t.Errorf("%v: expected signal %q, got %v", c.args, s, res.Signals)
+			}
+		}
+	}
+
+	if got, err := contextinfo.ResolvePath(repo, "share/../new/dir/../file"); err != nil || got != "/usr/new/file" {
+		t.Fatalf("expected /usr/new/file, got %q %v", got, err)
+	}
+	res := Evaluate([]string{"touch", "notes/new.txt"}, ctx, pol)
+	if containsString(res.Signals, "outside repo root") || containsString(res.Signals, "touches protected path") {
+		t.Fatalf("new file inside repo should not signal: %v", res.Signals)
+	}
+}
+
+func TestProtectedPathPatterns(t *testing.T) {
+	tmp := t.TempDir()
+	policyPath := filepath.Join(tmp, "clash.yaml")
+	yaml := "protected_paths:\n  - /etc\n  - .git\n  - \"**/.env*\"\n  - \"!.env.example\"\n  - path: secrets/**\n    action: block\n"
+	if err := os.WriteFile(policyPath, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pol, err := policy.Load(policyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+
+	cases := []struct {
+		args      []string
+		protected bool
+		want      DecisionType
+	}{
+		{[]string{"touch", ".git/config"}, true, DecisionConfirm},
+		{[]string{"touch", "app/.env.prod"}, true, DecisionConfirm},
+		{[]string{"touch", ".env.example"}, false, DecisionAllow},
+		{[]string{"touch", "/etcetera/x"}, false, DecisionConfirm},
+		{[]string{"cp", "/etc/hosts", "hosts"}, true, DecisionConfirm},
+		{[]string{"cp", "key", "secrets/key"}, true, DecisionBlock},
+		{[]string{"sh", "-c", "echo hi > secrets/x"}, true, DecisionBlock},
+	}
+	for _, c := range cases {
+		res := Evaluate(c.args, ctx, pol)
+		got := containsString(res.Signals, "touches protected path") || containsString(res.Signals, "redirect touches protected path")
+		if got != c.protected || res.Decision != c.want || res.Hard {
+			t.Errorf("%v: expected protected=%v %s, got %v %s hard=%v (%v %v)", c.args, c.protected, c.want, got, res.Decision, res.Hard, res.Reasons, res.Signals)
+		}
+	}
+
+	defaults, _ := policy.Load("")
+	for _, target := range []string{".env", "services/api/.env.local", "vendor/lib/.git/config"} {
+		res := Evaluate([]string{"touch", target}, ctx, defaults)
+		if !containsString(res.Signals, "touches protected path") {
+			t.Errorf("%s: expected the defaults to protect it, got %v", target, res.Signals)
+		}
+	}
+}
+
+func TestProtectedPathsUseCommandEnvironment(t *testing.T) {
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	data := filepath.Join(tmp, "data")
+	t.Setenv("HOME", filepath.Join(tmp, "host"))
+	t.Setenv("CLASH_DATA", filepath.Join(tmp, "host-data"))
+	policyPath := filepath.Join(tmp, "clash.yaml")
+	if err := os.WriteFile(policyPath, []byte("protected_paths:\n  - ~/.ssh\n  - $CLASH_DATA\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pol, err := policy.Load(policyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true, Env: []string{"HOME=" + home, "CLASH_DATA=" + data}}
+
+	cases := []struct {
+		target    string
+		protected bool
+	}{
+		{filepath.Join(home, ".ssh", "config"), true},
+		{filepath.Join(data, "db"), true},
+		{filepath.Join(tmp, "host", ".ssh", "config"), false},
+		{filepath.Join(tmp, "host-data", "db"), false},
+	}
+	for _, c := range cases {
+		res := Evaluate([]string{"touch", c.target}, ctx, pol)
+		if got := containsString(res.Signals, "touches protected path"); got != c.protected {
+			t.Errorf("%s: expected protected=%v, got %v", c.target, c.protected, res.Signals)
+		}
+	}
+
+	code := fmt.Sprintf("import shutil; shutil.rmtree('%s')", home)
+	if res := Evaluate([]string{"python3", "-c", code}, ctx, pol); res.Decision != DecisionBlock || !res.Hard {
+		t.Errorf("expected deleting the command's HOME to hard block, got %s %v", res.Decision, res.Reasons)
+	}
+	ctx.Env = nil
+	if res := Evaluate([]string{"python3", "-c", code}, ctx, pol); res.Hard {
+		t.Errorf("an unset HOME should not match %s: %v", home, res.Reasons)
+	}
+}
+
+func TestArgumentsAreExpanded(t *testing.T) {
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	repo := filepath.Join(tmp, "repo")
+	for _, dir := range []string{home, repo} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := contextinfo.Info{Cwd: repo, RepoRoot: repo, InRepo: true, Env: []string{"HOME=" + home, "PATH=" + os.Getenv("PATH")}}
+	pol, _ := policy.Load("")
+
+	for _, args := range [][]string{
+		{"rm", "-rf", "$HOME"},
+		{"rm", "-rf", "${HOME}/"},
+		{"rm", "-rf", "${UNSET:-/}"},
+		{"rm", "-rf", "$PWD/.."},
+		{"rm", "-rf", "~root/"},
+	} {
+		res := Evaluate(args, ctx, pol)
+		if res.Decision != DecisionBlock || !res.Hard {
+			t.Errorf("%v: expected hard block, got %s %v", args, res.Decision, res.Reasons)
+		}
+	}
+	if res := Evaluate([]string{"echo", "$HOME"}, ctx, pol); res.Decision != DecisionAllow {
+		t.Fatalf("expected allow, got %s %v", res.Decision, res.Reasons)
+	}
+}
+
+func TestConfirmCommandsFromPolicy(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+	pol.ConfirmCommands = []string{"kubectl delete", "terraform apply", "rm"}
+
+	cases := []struct {
+		args     []string
+		mutating bool
+	}{
+		{[]string{"kubectl", "delete", "pod", "web-0"}, true},
+		{[]string{"kubectl", "get", "pods"}, false},
+		{[]string{"terraform", "apply", "-auto-approve"}, true},
+		{[]string{"terraform", "applyx"}, false},
+		{[]string{"rm", "notes.txt"}, true},
+		{[]string{"mv", "a", "b"}, false},
+	}
+	for _, c := range cases {
+		res := Evaluate(c.args, ctx, pol)
+		if got := containsString(res.Signals, "mutating command"); got != c.mutating {
+			t.Errorf("%v: expected mutating=%v, got %v", c.args, c.mutating, res.Signals)
+		}
+	}
+}
+
+func TestThresholdsEscalatePreviews(t *testing.T) {
+	tmp := t.TempDir()
+	build := filepath.Join(tmp, "build")
+	if err := os.Mkdir(build, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 60; i++ {
+		if err := os.WriteFile(filepath.Join(build, fmt.Sprintf("f%d", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	pol, _ := policy.Load("")
+
+	res := Evaluate([]string{"rm", "-r", "build"}, ctx, pol)
+	if res.Decision != DecisionConfirm || res.PreviewHint == nil || !res.PreviewHint.Recursive {
+		t.Fatalf("expected confirm with recursive preview, got %s %+v", res.Decision, res.PreviewHint)
+	}
+	pr := preview.Run(*res.PreviewHint, ctx, pol.Thresholds.PreviewSample, 0)
+	if pr.Count != 61 {
+		t.Fatalf("expected 61 previewed paths, got %d", pr.Count)
+	}
+	escalated, hit := ApplyThresholds(res, *res.PreviewHint, pr, pol.Thresholds)
+	if hit == nil || hit.Name != "delete_count" || hit.Action != policy.ThresholdTyped || escalated.Decision != DecisionConfirm {
+		t.Fatalf("expected typed delete_count hit, got %+v %s", hit, escalated.Decision)
+	}
+	if hit.Phrase() != "delete 61 items" {
+		t.Fatalf("unexpected phrase %q", hit.Phrase())
+	}
+
+	pol.Thresholds.ExceededAction = policy.ThresholdBlock
+	escalated, hit = ApplyThresholds(res, *res.PreviewHint, pr, pol.Thresholds)
+	if hit == nil || escalated.Decision != DecisionBlock || escalated.Hard {
+		t.Fatalf("expected soft block, got %+v %s", hit, escalated.Decision)
+	}
+
+	limited := preview.Run(*res.PreviewHint, ctx, pol.Thresholds.PreviewSample, 10)
+	if !limited.Truncated || limited.Count != 11 || !strings.Contains(limited.Note, "stopped counting after 11") {
+		t.Fatalf("expected the walk to stop after 11 paths, got %d %q", limited.Count, limited.Note)
+	}
+	if _, hit = ApplyThresholds(res, *res.PreviewHint, limited, policy.Thresholds{DeleteCount: 10}); hit == nil {
+		t.Fatal("a truncated count should still exceed the threshold it stopped at")
+	}
+
+	res = Evaluate([]string{"chmod", "-R", "go-w", "build"}, ctx, pol)
+	pr = preview.Run(*res.PreviewHint, ctx, pol.Thresholds.PreviewSample, 0)
+	if _, hit = ApplyThresholds(res, *res.PreviewHint, pr, pol.Thresholds); hit != nil {
+		t.Fatalf("61 modifications should stay under modify_count: %+v", hit)
+	}
+}
+
+func TestLayeredPolicyLocks(t *testing.T) {
+	tmp := t.TempDir()
+	repo := filepath.Join(tmp, "repo")
+	sub := filepath.Join(repo, "services", "api")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(tmp, "system.yaml"): "locked: [thresholds, allow_commands, block_commands, protected_paths]\nthresholds:\n  delete_count: 10\nblock_commands: [mkfs, dd, terraform]\n",
+		filepath.Join(repo, "clash.yaml"): "thresholds:\n  delete_count: 1000\nallow_commands: [ls, rm]\nblock_commands: [mkfs]\nprotected_paths:\n  - \"!/etc\"\n",
+		filepath.Join(sub, "clash.yaml"):  "block_commands: [helm]\n",
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := policy.SystemPath
+	policy.SystemPath = filepath.Join(tmp, "system.yaml")
+	defer func() { policy.SystemPath = saved }()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+
+	res, err := policy.Resolve(repo, sub, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := contextinfo.Info{Cwd: sub, RepoRoot: repo, InRepo: true}
+	cases := []struct {
+		args []string
+		want DecisionType
+	}{
+		{[]string{"terraform", "destroy"}, DecisionBlock},
+		{[]string{"dd", "if=/dev/zero"}, DecisionBlock},
+		{[]string{"helm", "install", "x"}, DecisionBlock},
+		{[]string{"rm", "file"}, DecisionConfirm},
+		{[]string{"touch", "/etc/hosts"}, DecisionConfirm},
+		{[]string{"ls"}, DecisionAllow},
+	}
+	for _, c := range cases {
+		if got := Evaluate(c.args, ctx, res.Policy); got.Decision != c.want {
+			t.Errorf("%v: expected %s, got %s (%v %v)", c.args, c.want, got.Decision, got.Reasons, got.Signals)
+		}
+	}
+}
+
+func TestListMergeDirectives(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, data string) policy.Layer {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return policy.Layer{Name: strings.TrimSuffix(name, ".yaml"), Path: path}
+	}
+	admin := write("system.yaml", "locked: [block_commands]\nblock_commands: !append [terraform]\n")
+	repo := write("repo.yaml", "network_egress:\n  append: [terraform]\n  remove: [scp]\nblock_commands: !remove [dd, terraform]\n")
+	res, err := policy.LoadLayers([]policy.Layer{admin, repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+	cases := []struct {
+		args []string
+		want DecisionType
+	}{
+		{[]string{"terraform", "apply"}, DecisionBlock},
+		{[]string{"dd", "if=/dev/zero"}, DecisionBlock},
+		{[]string{"scp", "a", "b"}, DecisionAllow},
+		{[]string{"curl", "example.com"}, DecisionConfirm},
+	}
+	for _, c := range cases {
+		if got := Evaluate(c.args, ctx, res.Policy); got.Decision != c.want {
+			t.Errorf("%v: expected %s, got %s (%v %v)", c.args, c.want, got.Decision, got.Reasons, got.Signals)
+		}
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, data string) policy.Layer {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return policy.Layer{Name: strings.TrimSuffix(name, ".yaml"), Path: path}
+	}
+	admin := write("system.yaml", `rules:
+  - id: no-curl-pipe
+    locked: true
+    match: {command: curl, argv: 'example\.invalid'}
+    action: block
+`)
+	repo := write("repo.yaml", `rules: !append
+  - id: build-cleanup
+    match: {command: rm, targets: "build/**"}
+    action: allow
+    reason: build output is disposable
+  - id: no-force-push-main
+    priority: 10
+    match:
+      command: git
+      subcommand: push
+      flags_present: [--force]
+      argv: '\bmain\b'
+    action: block
+    reason: force-pushing main
+    safer_alternative: git push --force-with-lease origin feature
+  - id: recursive-chmod
+    match: {command: chmod, flags_present: --recursive}
+    action: hard-block
+  - id: ci-deploy
+    match: {command: deploy, env: {CI: "true"}}
+    action: confirm
+  - id: allow-curl
+    priority: 100
+    match: {command: curl}
+    action: allow
+  - id: copy-into-secrets
+    match: {command: cp, targets: "secrets/**"}
+    action: block
+`)
+	res, err := policy.LoadLayers([]policy.Layer{admin, repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol := res.Policy
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true, Env: []string{"CI=true"}}
+
+	cases := []struct {
+		args []string
+		want DecisionType
+		hard bool
+		rule string
+	}{
+		{[]string{"rm", "-r", "build/out"}, DecisionAllow, false, "build-cleanup"},
+		{[]string{"rm", "-r", "build/out", "build/tmp"}, DecisionAllow, false, "build-cleanup"},
+		{[]string{"rm", "-r", "build/out", "src", ".env"}, DecisionConfirm, false, ""},
+		{[]string{"cp", "notes.txt", "secrets/notes.txt"}, DecisionBlock, false, "copy-into-secrets"},
+		{[]string{"rm", "src/main.go"}, DecisionConfirm, false, ""},
+		{[]string{"rm", "-rf", "/"}, DecisionBlock, true, ""},
+		{[]string{"git", "push", "-f", "origin", "main"}, DecisionBlock, false, "no-force-push-main"},
+		{[]string{"git", "push", "origin", "main"}, DecisionConfirm, false, ""},
+		{[]string{"chmod", "-R", "755", "build"}, DecisionBlock, true, "recursive-chmod"},
+		{[]string{"deploy", "prod"}, DecisionConfirm, false, "ci-deploy"},
+		{[]string{"curl", "https://example.invalid/x"}, DecisionBlock, false, "no-curl-pipe"},
+		{[]string{"curl", "https://example.com"}, DecisionAllow, false, "allow-curl"},
+	}
+	for _, c := range cases {
+		got := Evaluate(c.args, ctx, pol)
+		if got.Decision != c.want || got.Hard != c.hard {
+			t.Errorf("%v: expected %s hard=%v, got %s hard=%v (%v)", c.args, c.want, c.hard, got.Decision, got.Hard, got.Reasons)
+			continue
+		}
+		if c.rule != "" && (!containsString(got.Rules, c.rule) || !strings.HasPrefix(strings.Join(got.Reasons, "|"), "rule:"+c.rule)) {
+			t.Errorf("%v: expected rule %s in %v (%v)", c.args, c.rule, got.Rules, got.Reasons)
+		}
+	}
+
+	drop := write("drop.yaml", "rules: !remove [no-curl-pipe]\n")
+	res, err = policy.LoadLayers([]policy.Layer{admin, drop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Policy.Rules) != 1 || len(res.Warnings) != 1 {
+		t.Fatalf("locked rule removed: %v %v", res.Policy.Rules, res.Warnings)
+	}
+
+	bad := write("bad.yaml", "rules:\n  - id: x\n    match: {command: rm}\n    action: deny\n")
+	if _, err := policy.LoadLayers([]policy.Layer{bad}); err == nil || !strings.Contains(err.Error(), `unknown action "deny"`) {
+		t.Fatalf("expected action error, got %v", err)
+	}
+}
+
+func TestRuleConditions(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "clash.yaml")
+	data := `rules:
+  - id: no-force-push-main
+    match:
+      command: git
+      subcommand: push
+      when: 'cmd.flags.exists(f, f in ["-f", "--force"]) && ctx.git.branch == "main" && cmd.operands[0] == "origin"'
+    action: block
+  - id: many-touches
+    match: {command: touch, when: 'size(cmd.targets) > 10'}
+    action: confirm
+  - id: big-delete
+    match: {command: rm, when: 'preview.available && preview.count > 3'}
+    action: block
+  - id: small-recursive-delete
+    match: {command: rm, flags_present: -r, when: 'preview.count < 5'}
+    action: allow
+  - id: broken
+    match: {command: ls, when: 'cmd.nmae == "ls"'}
+    action: allow
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pol, err := policy.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true, Git: contextinfo.GitSummary{Branch: "main"}}
+
+	many := []string{"touch"}
+	for i := 0; i < 11; i++ {
+		many = append(many, fmt.Sprintf("f%d", i))
+	}
+	cases := []struct {
+		args []string
+		want DecisionType
+	}{
+		{[]string{"git", "push", "--force", "origin", "main"}, DecisionBlock},
+		{[]string{"git", "push", "origin", "main"}, DecisionConfirm},
+		{[]string{"touch", "a", "b"}, DecisionAllow},
+		{many, DecisionConfirm},
+		{[]string{"ls"}, DecisionConfirm},
+	}
+	for _, c := range cases {
+		if got := Evaluate(c.args, ctx, pol); got.Decision != c.want {
+			t.Errorf("%v: expected %s, got %s (%v)", c.args, c.want, got.Decision, got.Reasons)
+		}
+	}
+	if got := Evaluate([]string{"ls"}, ctx, pol); !strings.Contains(strings.Join(got.Reasons, " "), "unknown field cmd.nmae") {
+		t.Errorf("expected condition error in reasons, got %v", got.Reasons)
+	}
+
+	res := Evaluate([]string{"rm", "a", "b"}, ctx, pol)
+	if res.Decision != DecisionConfirm || res.PreviewHint == nil {
+		t.Fatalf("expected rm to confirm with a preview, got %s", res.Decision)
+	}
+	if got := ApplyPreviewRules(res, *res.PreviewHint, preview.Result{Count: 2}, ctx, pol); got.Decision != DecisionConfirm {
+		t.Errorf("small preview: expected confirm, got %s", got.Decision)
+	}
+	if got := ApplyPreviewRules(res, *res.PreviewHint, preview.Result{Count: 5}, ctx, pol); got.Decision != DecisionBlock || !containsString(got.Rules, "big-delete") {
+		t.Errorf("large preview: expected big-delete block, got %s %v", got.Decision, got.Rules)
+	}
+
+	// An allow rule on preview fields waits for the preview instead of
+	// matching the zero count it would see before.
+	res = Evaluate([]string{"rm", "-r", "build"}, ctx, pol)
+	if res.Decision != DecisionConfirm || res.PreviewHint == nil || len(res.Rules) != 0 {
+		t.Fatalf("expected rm -r to confirm with a preview, got %s %v", res.Decision, res.Rules)
+	}
+	if got := ApplyPreviewRules(res, *res.PreviewHint, preview.Result{Count: 2}, ctx, pol); got.Decision != DecisionAllow || !containsString(got.Rules, "small-recursive-delete") {
+		t.Errorf("small recursive preview: expected allow, got %s %v", got.Decision, got.Rules)
+	}
+
+	if err := CompileCondition("size(cmd.args)"); err == nil {
+		t.Error("expected non-bool condition to fail")
+	}
+}
+
+func TestDetectorPlugins(t *testing.T) {
+	tmp := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	deploy := script("deploy.sh", `if grep -q '"subcommand":"prod"'; then
+  echo '{"signals":["production deploy"],"reasons":["deploys to prod"],"preview":{"kind":"modify","targets":["release"]}}'
+else
+  echo '{"decision":"allow"}'
+fi
+`)
+	broken := script("broken.sh", "echo 'no such cluster' >&2\nexit 3\n")
+	slow := script("slow.sh", "exec sleep 5\n")
+	garbled := script("garbled.sh", "echo '{\"decision\":\"maybe\"}'\n")
+	if err := os.MkdirAll(filepath.Join(tmp, "tools"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script(filepath.Join("tools", "lint.sh"), `echo '{"signals":["lint"],"reasons":["relative detector ran"]}'`+"\n")
+	data := fmt.Sprintf(`detectors:
+  - name: deploy
+    command: [%s]
+    match: acme-deploy
+  - name: broken
+    command: %s
+    match: kubectl
+  - name: slow
+    command: [%s]
+    match: terraform
+    timeout: 100ms
+  - name: garbled
+    command: [%s]
+    match: helm
+  - name: relative
+    command: [./tools/lint.sh]
+    match: acme-lint
+`, deploy, broken, slow, garbled)
+	path := filepath.Join(tmp, "clash.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pol, err := policy.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true}
+
+	res := Evaluate([]string{"acme-deploy", "prod"}, ctx, pol)
+	if res.Decision != DecisionConfirm || !containsString(res.Signals, "plugin:deploy: production deploy") {
+		t.Errorf("prod deploy: expected confirm with plugin signal, got %s %v", res.Decision, res.Signals)
+	}
+	if res.PreviewHint == nil || res.PreviewHint.Kind != preview.HintModify || res.PreviewHint.Args[0] != "acme-deploy" {
+		t.Errorf("prod deploy: expected modify preview hint, got %+v", res.PreviewHint)
+	}
+	if got := Evaluate([]string{"acme-deploy", "staging"}, ctx, pol); got.Decision != DecisionAllow {
+		t.Errorf("staging deploy: expected allow, got %s %v", got.Decision, got.Reasons)
+	}
+	// A plugin's allow does not clear what the ladder gates.
+	if got := Evaluate([]string{"sh", "-c", "acme-deploy staging && rm -rf build"}, ctx, pol); got.Decision == DecisionAllow {
+		t.Errorf("expected rm to stay gated, got %s", got.Decision)
+	}
+
+	// Relative commands resolve against the policy file, not the cwd.
+	sub := filepath.Join(tmp, "services", "api")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	subCtx := contextinfo.Info{Cwd: sub, RepoRoot: tmp, InRepo: true}
+	if got := Evaluate([]string{"acme-lint"}, subCtx, pol); got.Decision != DecisionConfirm || !containsString(got.Reasons, "plugin:relative: relative detector ran") {
+		t.Errorf("relative detector from a subdirectory: got %s %v", got.Decision, got.Reasons)
+	}
+
+	cases := []struct {
+		args   []string
+		reason string
+	}{
+		{[]string{"kubectl", "get", "pods"}, "plugin:broken: detector failed: exit status 3: no such cluster"},
+		{[]string{"terraform", "apply"}, "plugin:slow: detector failed: timed out after 100ms"},
+		{[]string{"helm", "install"}, `plugin:garbled: detector failed: unknown decision "maybe"`},
+	}
+	for _, c := range cases {
+		got := Evaluate(c.args, ctx, pol)
+		if got.Decision != DecisionBlock || got.Hard || !containsString(got.Reasons, c.reason) {
+			t.Errorf("%v: expected soft block %q, got %s %v", c.args, c.reason, got.Decision, got.Reasons)
+		}
+	}
+}
+
+func TestStarlarkScripts(t *testing.T) {
+	tmp := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmp, "checks"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"checks/terraform.star": `
+DESTRUCTIVE = ["destroy", "taint"]
+
+def evaluate(cmd, ctx):
+    if cmd.subcommand in DESTRUCTIVE:
+        return {"decision": "block", "reasons": ["terraform %s on %s" % (cmd.subcommand, ctx.git.branch)]}
+    if cmd.subcommand == "apply" and "-auto-approve" in cmd.args:
+        return {"signals": ["unreviewed apply"], "safer_alternative": "terraform plan"}
+    if cmd.subcommand == "plan":
+        return "allow"
+    return None
+`,
+		"checks/spin.star": `
+def evaluate(cmd, ctx):
+    n = 0
+    for i in range(100000000):
+        n += i
+    return "allow"
+`,
+		"checks/bad.star": `
+def evaluate(cmd, ctx):
+    return 42
+`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := `starlark:
+  - name: terraform
+    path: checks/terraform.star
+    match: terraform
+  - name: spin
+    path: checks/spin.star
+    match: make
+    max_steps: 10000
+  - name: bad
+    path: checks/bad.star
+    match: helm
+`
+	path := filepath.Join(tmp, "clash.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pol, err := policy.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: tmp, RepoRoot: tmp, InRepo: true, Git: contextinfo.GitSummary{Branch: "main"}}
+
+	cases := []struct {
+		args   []string
+		want   DecisionType
+		reason string
+	}{
+		{[]string{"terraform", "destroy"}, DecisionBlock, "starlark:terraform: terraform destroy on main"},
+		{[]string{"terraform", "apply", "-auto-approve"}, DecisionConfirm, "starlark:terraform: flagged without a reason"},
+		{[]string{"terraform", "plan"}, DecisionAllow, ""},
+		{[]string{"terraform", "fmt"}, DecisionAllow, ""},
+		{[]string{"make", "all"}, DecisionBlock, "starlark:spin: script failed: "},
+		{[]string{"helm", "list"}, DecisionBlock, "starlark:bad: script failed: evaluate returned int, want None, a string or a dict"},
+	}
+	for _, c := range cases {
+		got := Evaluate(c.args, ctx, pol)
+		if got.Decision != c.want {
+			t.Errorf("%v: expected %s, got %s (%v)", c.args, c.want, got.Decision, got.Reasons)
+			continue
+		}
+		if c.reason != "" && !strings.HasPrefix(strings.Join(got.Reasons, "\n"), c.reason) {
+			t.Errorf("%v: expected reason %q, got %v", c.args, c.reason, got.Reasons)
+		}
+	}
+	if got := Evaluate([]string{"terraform", "apply", "-auto-approve"}, ctx, pol); got.SaferAlternative != "terraform plan" || !containsString(got.Signals, "starlark:terraform: unreviewed apply") {
+		t.Errorf("expected script signal and safer alternative, got %v %q", got.Signals, got.SaferAlternative)
+	}
+	if got := Evaluate([]string{"make", "all"}, ctx, pol); !strings.Contains(strings.Join(got.Reasons, " "), "too many steps") {
+		t.Errorf("expected step limit in reasons, got %v", got.Reasons)
+	}
+}
@@ -0,0 +1,105 @@
+package classifier
+
+import (
+	"os"
+	"os/user"
+	"strings"
+
+	"clash/internal/contextinfo"
+)
+
+// expandArgs applies the shell's tilde and parameter expansion to arguments
+// that were passed literally (exec arrays, quoted words), using the
+// environment the command will run with. The bool is false when nothing
+// would change.
+func expandArgs(args []string, ctx contextinfo.Info) ([]string, bool) {
+	out := make([]string, len(args))
+	changed := false
+	for i, a := range args {
+		out[i] = expandWord(a, ctx)
+		if out[i] != a {
+			changed = true
+		}
+	}
+	return out, changed
+}
+
+func expandWord(w string, ctx contextinfo.Info) string {
+	if strings.HasPrefix(w, "~") {
+		w = expandTilde(w, ctx)
+	}
+	if !strings.Contains(w, "$") {
+		return w
+	}
+	return os.Expand(w, func(name string) string {
+		return expandParam(name, ctx)
+	})
+}
+
+// expandTilde handles ~, ~/path, ~user/path, ~+ and ~-.
+func expandTilde(w string, ctx contextinfo.Info) string {
+	prefix, rest := w[1:], ""
+	if i := strings.Index(prefix, "/"); i >= 0 {
+		prefix, rest = prefix[:i], prefix[i:]
+	}
+	var dir string
+	switch prefix {
+	case "":
+		dir = ctx.Getenv("HOME")
+		if dir == "" {
+			if u, err := user.Current(); err == nil {
+				dir = u.HomeDir
+			}
+		}
+	case "+":
+		dir = ctx.Cwd
+	case "-":
+		dir = ctx.Getenv("OLDPWD")
+	default:
+		if u, err := user.Lookup(prefix); err == nil {
+			dir = u.HomeDir
+		}
+	}
+	if dir == "" {
+		return w
+	}
+	return dir + rest
+}
+
+// expandParam resolves NAME and the ${NAME:-word} family of defaults.
+// Unset variables expand to nothing, as in the shell.
+func expandParam(expr string, ctx contextinfo.Info) string {
+	name, op, word := expr, "", ""
+	for _, candidate := range []string{":-", ":=", ":+", "-", "=", "+"} {
+		if i := strings.Index(expr, candidate); i > 0 {
+			name, op, word = expr[:i], candidate, expr[i+len(candidate):]
+			break
+		}
+	}
+	value, set := ctx.LookupEnv(name)
+	if name == "PWD" {
+		// The shell sets PWD to its working directory at startup.
+		value, set = ctx.Cwd, true
+	}
+	switch op {
+	case ":-", ":=":
+		if value == "" {
+			return expandWord(word, ctx)
+		}
+	case "-", "=":
+		if !set {
+			return expandWord(word, ctx)
+		}
+	case ":+":
+		if value != "" {
+			return expandWord(word, ctx)
+		}
+		return ""
+	case "+":
+		if set {
+			return expandWord(word, ctx)
+		}
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,158 @@
+package classifier
+
+import (
+	"regexp"
+	"strings"
+
+	"clash/internal/policy"
+)
+
+// parsedArgs is an argument list split into canonical flags and operands.
+type parsedArgs struct {
+	flags    map[string]int
+	values   map[string][]string
+	operands []string
+	// dashdash is the index in operands of the first word after "--", or -1.
+	dashdash int
+}
+
+// has reports whether any of the canonical flags is present.
+func (p parsedArgs) has(flags ...string) bool {
+	for _, f := range flags {
+		if p.flags[f] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// commandKey returns the command (or "command subcommand") identifying a
+// normalized argv and the arguments that follow it.
+func commandKey(args []string) (string, []string) {
+	if len(args) > 1 && subcommandTools[args[0]] && !strings.HasPrefix(args[1], "-") {
+		return args[0] + " " + args[1], args[2:]
+	}
+	return args[0], args[1:]
+}
+
+// parseCommand parses a normalized argv with the command's argument spec.
+// The bool is false when no spec is registered for the command.
+func parseCommand(args []string, p policy.Policy) (parsedArgs, bool) {
+	spec, rest, ok := lookupArgSpec(args, p)
+	return parseArgs(rest, spec), ok
+}
+
+// parseArgs normalizes options the way getopt_long does: short flags may be
+// clustered (-rfv), values may be attached or separate, long options may be
+// abbreviated to a unique prefix and options may follow operands until "--".
+func parseArgs(args []string, spec policy.ArgSpec) parsedArgs {
+	out := parsedArgs{flags: map[string]int{}, values: map[string][]string{}, dashdash: -1}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			out.dashdash = len(out.operands)
+			out.operands = append(out.operands, args[i+1:]...)
+			return out
+		case spec.StopAtOption && len(out.operands) > 0 && (strings.HasPrefix(a, "-") || a == "(" || a == "!"):
+			// find-style expressions: operands end where the expression starts.
+			return out
+		case spec.DashOperands != "" && isDashOperand(spec, a):
+			out.operands = append(out.operands, a)
+		case strings.HasPrefix(a, "--"):
+			name, value, hasValue := a, "", false
+			if eq := strings.Index(a, "="); eq >= 0 {
+				name, value, hasValue = a[:eq], a[eq+1:], true
+			}
+			name = canonicalFlag(spec, expandLong(spec, name))
+			if !hasValue && takesValue(spec, name) && i+1 < len(args) {
+				value, hasValue = args[i+1], true
+				i++
+			}
+			out.add(name, value, hasValue)
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for j := 1; j < len(a); j++ {
+				name := canonicalFlag(spec, "-"+string(a[j]))
+				if !takesValue(spec, name) {
+					out.add(name, "", false)
+					continue
+				}
+				if j+1 < len(a) {
+					out.add(name, a[j+1:], true)
+				} else if i+1 < len(args) {
+					out.add(name, args[i+1], true)
+					i++
+				} else {
+					out.add(name, "", false)
+				}
+				break
+			}
+		default:
+			out.operands = append(out.operands, a)
+		}
+	}
+	return out
+}
+
+func (p *parsedArgs) add(flag, value string, hasValue bool) {
+	p.flags[flag]++
+	if hasValue {
+		p.values[flag] = append(p.values[flag], value)
+	}
+}
+
+// isDashOperand reports whether a word starting with "-" is an operand of the
+// command, such as chmod's -x mode.
+func isDashOperand(s policy.ArgSpec, a string) bool {
+	if !strings.HasPrefix(a, "-") {
+		return false
+	}
+	ok, _ := regexp.MatchString("^(?:"+s.DashOperands+")$", a)
+	return ok
+}
+
+func canonicalFlag(s policy.ArgSpec, flag string) string {
+	if c, ok := s.Aliases[flag]; ok {
+		return c
+	}
+	return flag
+}
+
+func takesValue(s policy.ArgSpec, flag string) bool {
+	for _, f := range s.ValueFlags {
+		if canonicalFlag(s, f) == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// expandLong resolves an abbreviated long option to the single known option
+// it prefixes; unknown or ambiguous names are returned unchanged.
+func expandLong(s policy.ArgSpec, name string) string {
+	known := []string{}
+	for alias := range s.Aliases {
+		known = append(known, alias)
+	}
+	known = append(known, s.Flags...)
+	known = append(known, s.ValueFlags...)
+	match := ""
+	for _, k := range known {
+		if !strings.HasPrefix(k, "--") {
+			continue
+		}
+		if k == name {
+			return name
+		}
+		if strings.HasPrefix(k, name) {
+			if match != "" && match != k {
+				return name
+			}
+			match = k
+		}
+	}
+	if match != "" {
+		return match
+	}
+	return name
+}
@@ -0,0 +1,207 @@
+package classifier
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// gitValueOptions are global git options that take a value.
+var gitValueOptions = []string{"-C", "-c", "--git-dir", "--work-tree", "--namespace", "--config-env", "--super-prefix", "--exec-path", "--list-cmds", "--attr-source"}
+
+// gitRiskyConfig are configuration keys (or key prefixes ending in ".")
+// whose values make git run other programs or load other configuration.
+var gitRiskyConfig = []string{
+	"core.hookspath", "core.sshcommand", "core.pager", "core.editor", "core.fsmonitor",
+	"core.gitproxy", "core.askpass", "sequence.editor", "credential.helper", "diff.external",
+	"gpg.program", "include.path", "includeif.", "alias.", "filter.", "uploadpack.packobjectshook",
+	"protocol.allow", "safe.directory", "pager.",
+}
+
+// gitBuiltins are git commands that cannot be overridden by aliases.
+var gitBuiltins = map[string]bool{
+	"add": true, "am": true, "apply": true, "archive": true, "bisect": true, "blame": true,
+	"branch": true, "bundle": true, "cat-file": true, "checkout": true, "cherry": true,
+	"cherry-pick": true, "clean": true, "clone": true, "commit": true, "config": true,
+	"describe": true, "diff": true, "fetch": true, "filter-branch": true, "format-patch": true,
+	"fsck": true, "gc": true, "grep": true, "help": true, "init": true, "log": true,
+	"ls-files": true, "ls-remote": true, "ls-tree": true, "maintenance": true, "merge": true,
+	"mv": true, "notes": true, "prune": true, "pull": true, "push": true, "range-diff": true,
+	"rebase": true, "reflog": true, "remote": true, "repack": true, "replace": true,
+	"reset": true, "restore": true, "rev-list": true, "rev-parse": true, "revert": true,
+	"rm": true, "shortlog": true, "show": true, "sparse-checkout": true, "stash": true,
+	"status": true, "submodule": true, "switch": true, "symbolic-ref": true, "tag": true,
+	"update-index": true, "update-ref": true, "version": true, "worktree": true,
+}
+
+// gitCall is a git invocation with global options applied and aliases
+// expanded.
+type gitCall struct {
+	// args is the command as "git <subcommand> ...", without global options.
+	args []string
+	ctx  contextinfo.Info
+	// shell holds the script of a "!" alias.
+	shell   string
+	alias   string
+	signals []string
+}
+
+// gitAlias looks up alias.<name> in the repository and global configuration,
+// passing the invocation's global options so -c and -C are honoured.
+var gitAlias = func(globals []string, name string) (string, bool) {
+	cmd := exec.Command("git", append(append([]string{}, globals...), "config", "--get", "alias."+name)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimRight(string(out), "\n"), true
+}
+
+// unwrapGit strips git's global options and expands aliases. The bool is
+// false when the invocation needs no rewriting.
+func unwrapGit(args []string, ctx contextinfo.Info) (gitCall, bool) {
+	if args[0] != "git" || len(args) < 2 {
+		return gitCall{}, false
+	}
+	out := gitCall{ctx: ctx}
+	cwd := ctx.Cwd
+	workTree, gitDir := "", ""
+	globals := []string{}
+	i := 1
+	for ; i < len(args); i++ {
+		a := args[i]
+		if !strings.HasPrefix(a, "-") {
+			break
+		}
+		opt := parseWrapperOption(a, args[i+1:], gitValueOptions)
+		i += opt.consumed
+		abs := func(p string) string {
+			if filepath.IsAbs(p) {
+				return p
+			}
+			return filepath.Join(cwd, p)
+		}
+		switch opt.valueFlag {
+		case "-C":
+			// Each -C is interpreted relative to the preceding one.
+			if opt.value != "" {
+				cwd = abs(opt.value)
+			}
+			continue
+		case "--work-tree":
+			workTree = abs(opt.value)
+		case "--git-dir":
+			gitDir = abs(opt.value)
+		case "-c":
+			key, value, _ := strings.Cut(opt.value, "=")
+			if key = strings.ToLower(key); isRiskyGitConfig(key, value) {
+				out.signals = append(out.signals, "git config override: "+key)
+			}
+			globals = append(globals, "-c", opt.value)
+			continue
+		case "--config-env":
+			key, _, _ := strings.Cut(opt.value, "=")
+			if key = strings.ToLower(key); isRiskyGitConfig(key, "") {
+				out.signals = append(out.signals, "git config override: "+key)
+			}
+		case "--exec-path":
+			if opt.value != "" {
+				out.signals = append(out.signals, "git exec path override")
+			}
+		}
+		globals = append(globals, args[i-opt.consumed:i+1]...)
+	}
+	if i >= len(args) {
+		return gitCall{}, false
+	}
+	rest := args[i:]
+	changed := i > 1
+
+	if cwd != ctx.Cwd || workTree != "" || gitDir != "" {
+		out.ctx = contextinfo.DetectWorkTree(cwd, workTree, gitDir)
+		out.ctx.Env = ctx.Env
+	}
+
+	if !gitBuiltins[rest[0]] {
+		lookup := append([]string{"-C", cwd}, globals...)
+		if value, ok := gitAlias(lookup, rest[0]); ok && value != "" {
+			out.alias = rest[0] + " = " + value
+			if strings.HasPrefix(value, "!") {
+				// Shell aliases run from the top of the work tree with the
+				// remaining arguments appended.
+				out.ctx.Cwd = out.ctx.RepoRoot
+				out.shell = strings.TrimPrefix(value, "!")
+				for _, a := range rest[1:] {
+					out.shell += " " + shellQuote(a)
+				}
+				return out, true
+			}
+			expanded := splitAlias(value)
+			if len(expanded) == 0 {
+				return gitCall{}, false
+			}
+			rest = append(expanded, rest[1:]...)
+			changed = true
+		}
+	}
+	if !changed {
+		return gitCall{}, false
+	}
+	out.args = append([]string{"git"}, rest...)
+	return out, true
+}
+
+// evaluateGit classifies the rewritten git invocation, noting the alias in
+// the reasons when it affects the decision.
+func evaluateGit(g gitCall, p policy.Policy, nest nesting) Result {
+	var res Result
+	if g.shell != "" {
+		res = evaluateShell(g.shell, g.ctx, p, nest.deeper())
+	} else {
+		res = evaluateCommand(g.args, g.ctx, p, nest.deeper())
+	}
+	if g.alias != "" && res.Decision != DecisionAllow {
+		res.Reasons = append([]string{"git alias " + g.alias}, res.Reasons...)
+	}
+	return withSignals(res, g.signals...)
+}
+
+// gitSafePagers are pager settings that only display output.
+var gitSafePagers = map[string]bool{"": true, "cat": true, "less": true, "more": true, "false": true}
+
+func isRiskyGitConfig(key, value string) bool {
+	if (key == "core.pager" || strings.HasPrefix(key, "pager.")) && gitSafePagers[strings.TrimSpace(value)] {
+		return false
+	}
+	for _, risky := range gitRiskyConfig {
+		if key == risky || (strings.HasSuffix(risky, ".") && strings.HasPrefix(key, risky)) {
+			return true
+		}
+	}
+	// Per-driver programs: diff.<driver>.textconv, merge.<driver>.driver, ...
+	return strings.HasSuffix(key, ".textconv") || strings.HasSuffix(key, ".command") ||
+		(strings.HasPrefix(key, "merge.") && strings.HasSuffix(key, ".driver"))
+}
+
+// splitAlias splits a non-shell alias into words the way git does, honouring
+// quotes.
+func splitAlias(value string) []string {
+	file, err := syntax.NewParser().Parse(strings.NewReader(value), "")
+	if err != nil || len(file.Stmts) == 0 {
+		return strings.Fields(value)
+	}
+	call, ok := file.Stmts[0].Cmd.(*syntax.CallExpr)
+	if !ok {
+		return strings.Fields(value)
+	}
+	return wordsToArgv(call.Args)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
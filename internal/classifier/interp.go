@@ -0,0 +1,363 @@
+package classifier
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// Finding kinds reported by the interpreter scanner.
+const (
+	findingDestructive = "destructive"
+	findingShell       = "shell"
+	findingNetwork     = "network"
+	findingDynamic     = "dynamic"
+)
+
+// interpreterAPI is a call the scanner looks for.
+type interpreterAPI struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+// codeFinding is one API use found in interpreter code.
+type codeFinding struct {
+	Kind string
+	API  string
+	// Line is 1-based within the scanned code.
+	Line int
+	// Args is the raw argument text following the API name.
+	Args string
+}
+
+// apis compiles API name patterns. Names must not be part of a longer
+// identifier; patterns starting with "\." match methods on any receiver.
+func apis(kind string, names ...string) []interpreterAPI {
+	out := []interpreterAPI{}
+	for _, n := range names {
+		expr := `(` + n + `)`
+		if !strings.HasPrefix(n, `\.`) {
+			expr = `(?:^|[^\w.$])` + expr
+		}
+		if last := n[len(n)-1]; last == '_' || (last >= 'a' && last <= 'z') || (last >= 'A' && last <= 'Z') {
+			expr += `\b`
+		}
+		out = append(out, interpreterAPI{kind: kind, pattern: regexp.MustCompile(expr)})
+	}
+	return out
+}
+
+func joinAPIs(groups ...[]interpreterAPI) []interpreterAPI {
+	out := []interpreterAPI{}
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+// interpreterAPIs lists, per language, the calls that delete files, run a
+// shell, reach the network or evaluate code built at runtime.
+var interpreterAPIs = map[string][]interpreterAPI{
+	"python": joinAPIs(
+		apis(findingDestructive, `shutil\.rmtree`, `os\.remove`, `os\.unlink`, `os\.rmdir`, `os\.removedirs`, `os\.truncate`, `\.unlink\(`, `\.rmdir\(`),
+		apis(findingShell, `os\.system`, `os\.popen`, `subprocess\.(?:run|call|check_call|check_output|Popen|getoutput|getstatusoutput)`, `os\.exec[lv]p?e?`, `os\.spawn[lv]p?e?`),
+		apis(findingNetwork, `urllib`, `requests\.`, `http\.client`, `socket\.`, `httpx\.`, `ftplib`, `smtplib`),
+		apis(findingDynamic, `exec\(`, `eval\(`, `compile\(`, `__import__\(`),
+	),
+	"node": joinAPIs(
+		apis(findingDestructive, `\.rmSync`, `\.rm\(`, `\.rmdirSync`, `\.rmdir\(`, `\.unlinkSync`, `\.unlink\(`, `rmSync`, `unlinkSync`, `rimraf`),
+		apis(findingShell, `execSync`, `execFileSync`, `spawnSync`, `exec\(`, `spawn\(`, `execFile\(`, `\.execSync`, `\.execFileSync`, `\.spawnSync`, `\.exec\(`, `\.spawn\(`, `\.execFile\(`),
+		apis(findingNetwork, `fetch\(`, `https?\.(?:get|request)`, `net\.connect`, `net\.createConnection`, `require\(['"]https?['"]\)`),
+		apis(findingDynamic, `eval\(`, `new Function\(`, `vm\.run`),
+	),
+	"perl": joinAPIs(
+		apis(findingDestructive, `unlink`, `rmtree`, `remove_tree`, `rmdir`),
+		apis(findingShell, `system`, "`", `qx`, `exec`),
+		apis(findingNetwork, `LWP::`, `HTTP::Tiny`, `IO::Socket`, `Net::`),
+		apis(findingDynamic, `eval`),
+	),
+	"ruby": joinAPIs(
+		apis(findingDestructive, `FileUtils\.rm_rf`, `FileUtils\.rm_r`, `FileUtils\.rm`, `FileUtils\.remove_dir`, `FileUtils\.remove_entry`, `File\.delete`, `File\.unlink`, `Dir\.rmdir`, `Dir\.delete`),
+		apis(findingShell, `system`, "`", `%x`, `exec`, `IO\.popen`, `Open3\.`, `spawn`),
+		apis(findingNetwork, `Net::HTTP`, `open-uri`, `URI\.open`, `TCPSocket`, `Socket\.`),
+		apis(findingDynamic, `eval`, `instance_eval`, `class_eval`),
+	),
+	"php": joinAPIs(
+		apis(findingDestructive, `unlink\(`, `rmdir\(`),
+		apis(findingShell, `shell_exec`, `system\(`, `exec\(`, `passthru`, `proc_open`, `popen`, "`"),
+		apis(findingNetwork, `curl_`, `fsockopen`, `file_get_contents\(\s*['"]https?:`, `stream_socket_client`),
+		apis(findingDynamic, `eval\(`, `assert\(`, `create_function`),
+	),
+}
+
+// inlineCodeFlags are the options that take program text, per language.
+var inlineCodeFlags = map[string][]string{
+	"python": {"-c"},
+	"node":   {"-e", "--eval", "-p", "--print"},
+	"perl":   {"-e", "-E"},
+	"ruby":   {"-e"},
+	"php":    {"-r", "--run"},
+}
+
+// interpreterLang maps an interpreter binary name (python3.11, nodejs) to its
+// language; it returns "" for other commands.
+func interpreterLang(name string) string {
+	base := strings.TrimRight(name, "0123456789.")
+	switch base {
+	case "python", "pypy":
+		return "python"
+	case "node", "nodejs":
+		return "node"
+	case "perl", "ruby", "php":
+		return base
+	}
+	return ""
+}
+
+// inlineCode returns the program text passed on the command line.
+func inlineCode(args []string) (string, string, bool) {
+	lang := interpreterLang(args[0])
+	if lang == "" {
+		return "", "", false
+	}
+	flags := inlineCodeFlags[lang]
+	code := []string{}
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			break
+		}
+		if eq := strings.Index(a, "="); strings.HasPrefix(a, "--") && eq >= 0 {
+			if containsString(flags, a[:eq]) {
+				code = append(code, a[eq+1:])
+			}
+			continue
+		}
+		if containsString(flags, a) {
+			if i+1 < len(args) {
+				code = append(code, args[i+1])
+				i++
+			}
+			if lang == "python" {
+				break
+			}
+			continue
+		}
+		// Clustered short flags (perl -lne, python -Ic) end with the code flag
+		// or carry the code attached (-c'print(1)').
+		if !strings.HasPrefix(a, "--") {
+			for j := 1; j < len(a); j++ {
+				if !containsString(flags, "-"+string(a[j])) {
+					continue
+				}
+				if j+1 < len(a) {
+					code = append(code, a[j+1:])
+				} else if i+1 < len(args) {
+					code = append(code, args[i+1])
+					i++
+				}
+				break
+			}
+		}
+	}
+	if len(code) == 0 {
+		return "", "", false
+	}
+	return lang, strings.Join(code, "\n"), true
+}
+
+// scanCode reports the APIs of interest used in code.
+func scanCode(lang, code string) []codeFinding {
+	findings := []codeFinding{}
+	lines := strings.Split(code, "\n")
+	for n, line := range lines {
+		for _, api := range interpreterAPIs[lang] {
+			loc := api.pattern.FindStringSubmatchIndex(line)
+			if loc == nil {
+				continue
+			}
+			name := line[loc[2]:loc[3]]
+			start := loc[3]
+			switch {
+			case strings.HasSuffix(name, "("):
+				start--
+			case name == "`":
+				// Backticks are their own argument.
+				start = loc[2]
+			}
+			findings = append(findings, codeFinding{
+				Kind: api.kind,
+				API:  strings.TrimPrefix(strings.TrimSuffix(name, "("), "."),
+				Line: n + 1,
+				Args: callArgs(line[start:]),
+			})
+		}
+	}
+	return findings
+}
+
+// callArgs returns the text of a call's arguments: up to the matching
+// parenthesis, or to the end of the statement for paren-less calls.
+func callArgs(s string) string {
+	s = strings.TrimLeft(s, " \t")
+	if !strings.HasPrefix(s, "(") {
+		if end := strings.IndexAny(s, ";\n"); end >= 0 {
+			return s[:end]
+		}
+		return s
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return s[1:i]
+			}
+		}
+	}
+	return s[1:]
+}
+
+// homeExpression matches the common ways code refers to the home directory.
+var homeExpression = regexp.MustCompile(`^(?:os\.path\.expanduser\(\s*['"]~['"]\s*\)|os\.environ\[\s*['"]HOME['"]\s*\]|os\.environ\.get\(\s*['"]HOME['"]\s*\)|(?:pathlib\.)?Path\.home\(\)|(?:require\(['"]os['"]\)|os)\.homedir\(\)|process\.env\.HOME|ENV\[\s*['"]HOME['"]\s*\]|\$ENV\{\s*['"]?HOME['"]?\s*\}|Dir\.home|(?:os\.)?getenv\(\s*['"]HOME['"]\s*\))$`)
+
+var leadingLiteral = regexp.MustCompile(`^[rRbBuUfF]{0,2}(?:'((?:[^'\\]|\\.)*)'|"((?:[^"\\]|\\.)*)"|` + "`([^`]*)`)")
+
+// leadingLiterals returns the comma-separated string literals at the start
+// of an argument list, looking inside a leading list literal.
+func leadingLiterals(args string) []string {
+	s := strings.TrimSpace(args)
+	s = strings.TrimPrefix(s, "[")
+	out := []string{}
+	for {
+		s = strings.TrimSpace(s)
+		m := leadingLiteral.FindStringSubmatch(s)
+		if m == nil {
+			return out
+		}
+		for _, g := range m[1:] {
+			if g != "" {
+				out = append(out, g)
+				break
+			}
+		}
+		s = strings.TrimSpace(s[len(m[0]):])
+		if !strings.HasPrefix(s, ",") {
+			return out
+		}
+		s = s[1:]
+	}
+}
+
+// firstArg returns the first top-level argument in an argument list.
+func firstArg(args string) string {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			return strings.TrimSpace(args[:i])
+		}
+	}
+	return strings.TrimSpace(args)
+}
+
+// isCatastrophicLiteral reports delete calls whose target is /, ~ or the
+// home directory, spelled as a literal or a well-known home expression.
+func isCatastrophicLiteral(args string, ctx contextinfo.Info) bool {
+	target := firstArg(args)
+	if homeExpression.MatchString(target) {
+		return true
+	}
+	// Only a target that is exactly one literal is judged; concatenations
+	// and joins name something below it.
+	if leadingLiteral.FindString(target) != target {
+		return false
+	}
+	// A call without an argument, or with an empty one, names nothing;
+	// the delete API alone still raises its signal.
+	lits := leadingLiterals(target)
+	if target == "" || len(lits) == 0 {
+		return false
+	}
+	lit := lits[0]
+	switch lit {
+	case "/", "~", "~/", "$HOME", "${HOME}":
+		return true
+	}
+	resolved, err := contextinfo.ResolvePath(ctx.Cwd, lit)
+	if err != nil {
+		return false
+	}
+	home, _ := os.UserHomeDir()
+	h := ctx.Getenv("HOME")
+	return resolved == "/" || (home != "" && resolved == home) || (h != "" && resolved == h)
+}
+
+// evaluateCode classifies interpreter code by the APIs it uses. Embedded
+// shell commands are fed back through the ladder.
+func evaluateCode(lang, code string, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	results := []Result{{Decision: DecisionAllow, Reasons: []string{"no risk signals"}}}
+	for _, f := range scanCode(lang, code) {
+		results = append(results, evaluateFinding(f, ctx, p, nest))
+	}
+	return combine(results)
+}
+
+func evaluateFinding(f codeFinding, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	switch f.Kind {
+	case findingDestructive:
+		if isCatastrophicLiteral(f.Args, ctx) {
+			return Result{
+				Decision:         DecisionBlock,
+				Hard:             true,
+				Reasons:          []string{"catastrophic interpreter delete target (" + f.API + ")"},
+				SaferAlternative: "narrow the path passed to " + f.API,
+			}
+		}
+		return withSignals(Result{Decision: DecisionAllow}, "interpreter destructive API: "+f.API)
+	case findingShell:
+		// A single string runs through a shell; a list (or several strings,
+		// as with perl's system LIST) is an argv.
+		lits := leadingLiterals(f.Args)
+		switch {
+		case len(lits) == 1 && !strings.HasPrefix(strings.TrimSpace(f.Args), "["):
+			return withSignals(evaluateShell(lits[0], ctx, p, nest.deeper()), "interpreter runs shell command")
+		case len(lits) > 0:
+			return withSignals(evaluateCommand(lits, ctx, p, nest.deeper()), "interpreter runs shell command")
+		}
+		return withSignals(Result{Decision: DecisionAllow}, "interpreter runs dynamic shell command")
+	case findingNetwork:
+		return withSignals(Result{Decision: DecisionAllow}, "interpreter network API: "+f.API)
+	}
+	return withSignals(Result{Decision: DecisionAllow}, "interpreter evaluates dynamic code")
+}
@@ -0,0 +1,119 @@
+package classifier
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"clash/internal/contextinfo"
+)
+
+// Executable records how a command name was resolved.
+type Executable struct {
+	// Requested is argv[0] exactly as given.
+	Requested string
+	// Resolved is the absolute binary path after PATH lookup and symlink
+	// resolution; empty when the binary could not be found.
+	Resolved string
+	// Name is the canonical command name used for policy matching.
+	Name string
+}
+
+// multiCallBinaries dispatch on their first argument (busybox rm ...) or on
+// the name they were invoked as (a symlink rm -> busybox).
+var multiCallBinaries = map[string]bool{
+	"busybox":   true,
+	"toybox":    true,
+	"coreutils": true,
+}
+
+// normalizeCommand rewrites argv so args[0] is a bare, lower-case command
+// name. The second return value describes the binary that would run; the
+// third is an alternate name to evaluate when a symlink points at a binary
+// with a different name (e.g. ./ls -> /bin/rm).
+func normalizeCommand(args []string, ctx contextinfo.Info) ([]string, Executable, string) {
+	requested := args[0]
+	// A leading backslash only bypasses shell aliases.
+	name := strings.TrimLeft(requested, `\`)
+	exe := Executable{Requested: requested, Resolved: lookPath(name, ctx.Cwd, ctx.Getenv("PATH"))}
+
+	base := strings.ToLower(filepath.Base(name))
+	if multiCallBinaries[base] {
+		if applet, rest, ok := multiCallApplet(base, args[1:]); ok {
+			argv, inner, alt := normalizeCommand(append([]string{applet}, rest...), ctx)
+			exe.Name = inner.Name
+			return argv, exe, alt
+		}
+	}
+	exe.Name = base
+
+	argv := append([]string{base}, args[1:]...)
+	alt := ""
+	if exe.Resolved != "" {
+		resolvedBase := strings.ToLower(filepath.Base(exe.Resolved))
+		if resolvedBase != base && !multiCallBinaries[resolvedBase] {
+			alt = resolvedBase
+		}
+	}
+	return argv, exe, alt
+}
+
+// multiCallApplet extracts the applet name from a multi-call invocation.
+func multiCallApplet(binary string, rest []string) (string, []string, bool) {
+	for len(rest) > 0 {
+		a := rest[0]
+		switch {
+		case binary == "coreutils" && strings.HasPrefix(a, "--coreutils-prog="):
+			return strings.TrimPrefix(a, "--coreutils-prog="), rest[1:], true
+		case a == "--":
+			rest = rest[1:]
+		case strings.HasPrefix(a, "-"):
+			// --help, --list, --install and friends do not run an applet.
+			return "", nil, false
+		default:
+			return a, rest[1:], true
+		}
+	}
+	return "", nil, false
+}
+
+// lookPath resolves name the way execve would, relative to cwd, and follows
+// symlinks. It returns "" when no executable is found.
+func lookPath(name, cwd, pathEnv string) string {
+	if name == "" {
+		return ""
+	}
+	if strings.Contains(name, "/") {
+		resolved, err := contextinfo.ResolvePath(cwd, name)
+		if err != nil || !isExecutable(resolved) {
+			return ""
+		}
+		return resolved
+	}
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if dir == "" {
+			dir = "."
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cwd, dir)
+		}
+		candidate := filepath.Join(dir, name)
+		if !isExecutable(candidate) {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(candidate)
+		if err != nil {
+			continue
+		}
+		return resolved
+	}
+	return ""
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return info.Mode()&0o111 != 0
+}
@@ -0,0 +1,229 @@
+package classifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+	"clash/internal/preview"
+)
+
+// DetectorProtocol is the version of the request sent to detector plugins.
+const DetectorProtocol = 1
+
+// maxDetectorOutput bounds how much of a detector's stdout is read.
+const maxDetectorOutput = 1 << 20
+
+// DetectorRequest is written as JSON to a detector's stdin.
+type DetectorRequest struct {
+	Version  int             `json:"version"`
+	Detector string          `json:"detector"`
+	Command  CommandFacts    `json:"command"`
+	Context  DetectorContext `json:"context"`
+}
+
+// DetectorContext is the part of contextinfo.Info a detector sees. The
+// environment is not repeated here; the detector inherits it.
+type DetectorContext struct {
+	Cwd      string                 `json:"cwd"`
+	RepoRoot string                 `json:"repo_root"`
+	InRepo   bool                   `json:"in_repo"`
+	Git      contextinfo.GitSummary `json:"git"`
+}
+
+// DetectorResponse is read as JSON from a detector's stdout. Decision is
+// allow, confirm or block; an empty decision means confirm when there are
+// signals and allow otherwise.
+type DetectorResponse struct {
+	Decision         string           `json:"decision"`
+	Signals          []string         `json:"signals"`
+	Reasons          []string         `json:"reasons"`
+	SaferAlternative string           `json:"safer_alternative"`
+	Preview          *DetectorPreview `json:"preview"`
+}
+
+// DetectorPreview asks clash to preview the command's effect before it is
+// confirmed.
+type DetectorPreview struct {
+	Kind      string   `json:"kind"`
+	Args      []string `json:"args"`
+	Targets   []string `json:"targets"`
+	Recursive bool     `json:"recursive"`
+}
+
+var (
+	detectorMu    sync.Mutex
+	detectorCache = map[string]Result{}
+)
+
+// applyDetectors runs the policy's detectors for a normalized argv and folds
+// what they report into res. Detectors can only make the decision stricter.
+func applyDetectors(res Result, args []string, ctx contextinfo.Info, p policy.Policy) Result {
+	if len(p.Detectors) == 0 {
+		return res
+	}
+	req := DetectorRequest{
+		Version: DetectorProtocol,
+		Command: DescribeCommand(args, p),
+		Context: DetectorContext{Cwd: ctx.Cwd, RepoRoot: ctx.RepoRoot, InRepo: ctx.InRepo, Git: ctx.Git},
+	}
+	results := []Result{res}
+	for _, d := range p.Detectors {
+		if len(d.Match) > 0 && !containsFold(d.Match, args[0]) {
+			continue
+		}
+		req.Detector = d.Name
+		results = append(results, runDetector(d, req, ctx))
+	}
+	return combine(results)
+}
+
+// runDetector runs one detector, caching its answer for the same request.
+// A detector that fails, times out or answers badly blocks the command.
+func runDetector(d policy.Detector, req DetectorRequest, ctx contextinfo.Info) Result {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return failClosed("plugin:"+d.Name, "detector", err)
+	}
+	key := d.Executable() + "\x00" + strings.Join(d.Command[1:], "\x00") + "\x00" + d.TimeoutDuration().String() + "\x00" + string(input)
+	detectorMu.Lock()
+	cached, ok := detectorCache[key]
+	detectorMu.Unlock()
+	if ok {
+		return cached
+	}
+
+	timeout := d.TimeoutDuration()
+	cctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, d.Executable(), d.Command[1:]...)
+	cmd.Dir = ctx.Cwd
+	cmd.Env = ctx.Env
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.WaitDelay = timeout / 2
+	var stdout limitedBuffer
+	var stderr bytes.Buffer
+	stdout.limit = maxDetectorOutput
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	var res Result
+	switch {
+	case cctx.Err() == context.DeadlineExceeded:
+		res = failClosed("plugin:"+d.Name, "detector", fmt.Errorf("timed out after %s", timeout))
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, firstLine(msg))
+		}
+		res = failClosed("plugin:"+d.Name, "detector", err)
+	default:
+		res = detectorResult(d.Name, stdout.Bytes(), req.Command.Argv)
+	}
+	detectorMu.Lock()
+	detectorCache[key] = res
+	detectorMu.Unlock()
+	return res
+}
+
+// detectorResult turns a detector's stdout into a Result.
+func detectorResult(name string, out []byte, args []string) Result {
+	source := "plugin:" + name
+	var resp DetectorResponse
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&resp); err != nil {
+		return failClosed(source, "detector", fmt.Errorf("invalid response: %v", err))
+	}
+	res, err := verdictResult(source, resp, args)
+	if err != nil {
+		return failClosed(source, "detector", err)
+	}
+	return res
+}
+
+// verdictResult turns a detector's or script's answer into a Result, with
+// signals and reasons prefixed by source.
+func verdictResult(source string, resp DetectorResponse, args []string) (Result, error) {
+	prefix := source + ": "
+	res := Result{SaferAlternative: resp.SaferAlternative}
+	for _, s := range resp.Signals {
+		res.Signals = append(res.Signals, prefix+s)
+	}
+	for _, r := range resp.Reasons {
+		res.Reasons = append(res.Reasons, prefix+r)
+	}
+	switch resp.Decision {
+	case "allow":
+		res.Decision = DecisionAllow
+	case "confirm":
+		res.Decision = DecisionConfirm
+	case "block":
+		res.Decision = DecisionBlock
+	case "":
+		res.Decision = DecisionAllow
+		if len(resp.Signals) > 0 {
+			res.Decision = DecisionConfirm
+		}
+	default:
+		return Result{}, fmt.Errorf("unknown decision %q", resp.Decision)
+	}
+	if res.Decision != DecisionAllow && len(res.Reasons) == 0 {
+		res.Reasons = []string{prefix + "flagged without a reason"}
+	}
+	if resp.Preview != nil {
+		hint, err := detectorHint(*resp.Preview, args)
+		if err != nil {
+			return Result{}, err
+		}
+		res.PreviewHint = &hint
+	}
+	return res, nil
+}
+
+func detectorHint(p DetectorPreview, args []string) (preview.Hint, error) {
+	kind := preview.HintKind(p.Kind)
+	switch kind {
+	case preview.HintRM, preview.HintFindDelete, preview.HintGitClean, preview.HintModify:
+	default:
+		return preview.Hint{}, fmt.Errorf("unknown preview kind %q", p.Kind)
+	}
+	hint := preview.Hint{Kind: kind, Args: p.Args, Targets: p.Targets, Recursive: p.Recursive}
+	if len(hint.Args) == 0 {
+		hint.Args = args
+	}
+	return hint, nil
+}
+
+// failClosed stands in for a detector or script (what) that cannot answer:
+// it blocks the command, though break-glass can still override it.
+func failClosed(source, what string, err error) Result {
+	return Result{
+		Decision: DecisionBlock,
+		Reasons:  []string{source + ": " + what + " failed: " + err.Error()},
+		Signals:  []string{source + ": " + what + " failed"},
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it and reports an
+// error once more arrive, which stops a runaway detector.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+var errDetectorOutput = errors.New("detector output too large")
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errDetectorOutput
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,131 @@
+package classifier
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// protectedHit is the protected_paths entry that decided a target.
+type protectedHit struct {
+	entry  policy.ProtectedPath
+	target string
+}
+
+// blocks reports whether the hit's action is a (soft) block.
+func (h protectedHit) blocks() bool {
+	return h.entry.Action == policy.ProtectedBlock
+}
+
+// protectedReason describes a protected path block.
+func (h protectedHit) reason() string {
+	return "protected path " + h.entry.Path + ": " + h.target
+}
+
+// findProtected returns the strictest protected_paths hit among targets.
+// Entries apply in order and the last matching one decides a target, so a
+// "!pattern" entry can unprotect paths matched earlier.
+func findProtected(targets []string, ctx contextinfo.Info, entries []policy.ProtectedPath) (protectedHit, bool) {
+	var hit protectedHit
+	found := false
+	for _, t := range targets {
+		resolved, err := contextinfo.ResolvePath(ctx.Cwd, t)
+		if err != nil {
+			continue
+		}
+		var decided *policy.ProtectedPath
+		for i := range entries {
+			if entries[i].Pattern() != "" && protectedMatches(entries[i], resolved, ctx) {
+				decided = &entries[i]
+			}
+		}
+		if decided == nil || decided.Negated() {
+			continue
+		}
+		if !found || (!hit.blocks() && decided.Action == policy.ProtectedBlock) {
+			hit = protectedHit{entry: *decided, target: t}
+			found = true
+		}
+	}
+	return hit, found
+}
+
+// protectedMatches matches one entry against a resolved absolute path.
+func protectedMatches(entry policy.ProtectedPath, resolved string, ctx contextinfo.Info) bool {
+	pattern := expandProtected(entry.Pattern(), ctx)
+	if filepath.IsAbs(pattern) {
+		if pathMatches(pattern, entry.Exact, resolved) {
+			return true
+		}
+		// A literal pattern may itself contain symlinks (/etc -> /private/etc).
+		if !hasGlobMeta(pattern) {
+			if real, err := contextinfo.ResolvePath("/", pattern); err == nil && real != pattern {
+				return pathMatches(real, entry.Exact, resolved)
+			}
+		}
+		return false
+	}
+	if !ctx.InRepo {
+		return false
+	}
+	roots := []string{ctx.RepoRoot}
+	if real, err := contextinfo.ResolvePath("/", ctx.RepoRoot); err == nil && real != ctx.RepoRoot {
+		roots = append(roots, real)
+	}
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if pathMatches(pattern, entry.Exact, filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathMatches matches a doublestar pattern component-wise: "/etc" matches
+// "/etc" and "/etc/hosts" but not "/etcetera".
+func pathMatches(pattern string, exact bool, path string) bool {
+	if ok, _ := doublestar.Match(pattern, path); ok {
+		return true
+	}
+	if exact {
+		return false
+	}
+	ok, _ := doublestar.Match(strings.TrimSuffix(pattern, "/")+"/**", path)
+	return ok
+}
+
+// expandProtected expands a leading ~ or $VAR in a pattern from the
+// command's environment.
+func expandProtected(p string, ctx contextinfo.Info) string {
+	switch {
+	case p == "~" || strings.HasPrefix(p, "~/"):
+		if h := ctx.Getenv("HOME"); h != "" {
+			return h + strings.TrimPrefix(p, "~")
+		}
+		if h, err := os.UserHomeDir(); err == nil {
+			return h + strings.TrimPrefix(p, "~")
+		}
+	case strings.HasPrefix(p, "$"):
+		name, rest := strings.TrimPrefix(p, "$"), ""
+		if i := strings.Index(name, "/"); i >= 0 {
+			name, rest = name[:i], name[i:]
+		}
+		name = strings.Trim(name, "{}")
+		if v := ctx.Getenv(name); v != "" {
+			return v + rest
+		}
+	}
+	return p
+}
+
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[{")
+}
@@ -0,0 +1,782 @@
+package classifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// recipe is one command a task runner would execute for a target.
+type recipe struct {
+	target string
+	file   string
+	line   int
+	text   string
+	// lang is the interpreter for non-shell recipe bodies (just shebang
+	// recipes); empty means the text is shell.
+	lang string
+	dir  string
+	// signals are raised while resolving the recipe, such as make
+	// variables that expand to nothing.
+	signals []string
+}
+
+// recipeResolver expands a task runner invocation into the recipes it runs.
+// The bool is false when the targets cannot be found, in which case the
+// command is classified as written.
+type recipeResolver func(args []string, ctx contextinfo.Info) ([]recipe, bool)
+
+var recipeResolvers = map[string]recipeResolver{
+	"make":  resolveMake,
+	"gmake": resolveMake,
+	"npm":   resolvePackageScripts,
+	"yarn":  resolvePackageScripts,
+	"pnpm":  resolvePackageScripts,
+	"bun":   resolvePackageScripts,
+	"just":  resolveJust,
+	"task":  resolveTask,
+}
+
+// evaluateRecipes classifies every recipe line a task runner would execute.
+// The strictest line wins and its origin is added to the reasons.
+func evaluateRecipes(args []string, ctx contextinfo.Info, p policy.Policy, nest nesting) (Result, bool) {
+	resolve, ok := recipeResolvers[args[0]]
+	if !ok {
+		return Result{}, false
+	}
+	recipes, ok := resolve(args, ctx)
+	if !ok {
+		return Result{}, false
+	}
+	results := []Result{evaluateSimple(args, ctx, p)}
+	for _, r := range recipes {
+		rctx := ctx
+		rctx.Cwd = r.dir
+		var res Result
+		if r.lang != "" && r.lang != "shell" {
+			res = evaluateCode(r.lang, r.text, rctx, p, nest.deeper())
+		} else {
+			res = evaluateShell(r.text, rctx, p, nest.deeper())
+		}
+		res = withSignals(res, r.signals...)
+		if res.Decision != DecisionAllow {
+			origin := fmt.Sprintf("recipe %s (%s:%d): %s", r.target, displayPath(r.file, ctx), r.line, firstLine(r.text))
+			res.Reasons = append([]string{origin}, res.Reasons...)
+		}
+		results = append(results, res)
+	}
+	return combine(results), true
+}
+
+// findRunnerFile looks for the first of names in the working directory and
+// its parents up to the repository root.
+func findRunnerFile(ctx contextinfo.Info, names ...string) string {
+	dir := ctx.Cwd
+	for {
+		for _, name := range names {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+		parent := filepath.Dir(dir)
+		if !ctx.InRepo || dir == ctx.RepoRoot || parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func displayPath(path string, ctx contextinfo.Info) string {
+	if ctx.InRepo {
+		if rel, err := filepath.Rel(ctx.RepoRoot, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "\n"); i >= 0 {
+		return s[:i] + " ..."
+	}
+	return s
+}
+
+// runnerArgs splits a task runner argv into options and positional words.
+// valueFlags consume a value; noExecFlags mean nothing would be run.
+func runnerArgs(args []string, valueFlags, noExecFlags []string) (map[string]string, []string, bool) {
+	opts := map[string]string{}
+	positional := []string{}
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") || len(a) == 1 {
+			positional = append(positional, a)
+			continue
+		}
+		opt := parseWrapperOption(a, args[i+1:], valueFlags)
+		i += opt.consumed
+		for _, f := range opt.flags {
+			if containsString(noExecFlags, f) {
+				return nil, nil, false
+			}
+		}
+		if opt.valueFlag != "" {
+			opts[opt.valueFlag] = opt.value
+		}
+	}
+	return opts, positional, true
+}
+
+// optionValue returns the value of the first option present.
+func optionValue(opts map[string]string, names ...string) string {
+	for _, n := range names {
+		if v, ok := opts[n]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// runnerContext applies a runner's directory option to the context.
+func runnerContext(ctx contextinfo.Info, dir string) contextinfo.Info {
+	if dir == "" {
+		return ctx
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(ctx.Cwd, dir)
+	}
+	ctx.Cwd = dir
+	return ctx
+}
+
+// runnerFile resolves an explicit file option or searches for the defaults.
+func runnerFile(ctx contextinfo.Info, explicit string, names ...string) string {
+	if explicit == "" {
+		return findRunnerFile(ctx, names...)
+	}
+	if !filepath.IsAbs(explicit) {
+		explicit = filepath.Join(ctx.Cwd, explicit)
+	}
+	return explicit
+}
+
+// --- make ---
+
+type makeRule struct {
+	deps  []string
+	lines []recipe
+}
+
+type makefile struct {
+	path  string
+	vars  map[string]string
+	rules map[string]*makeRule
+	first string
+}
+
+// makeBuiltinVars are the variables GNU make defines before reading a
+// makefile; recipes commonly run $(RM) or $(CC) without setting them.
+var makeBuiltinVars = map[string]string{
+	"AR":       "ar",
+	"ARFLAGS":  "rv",
+	"AS":       "as",
+	"CC":       "cc",
+	"CO":       "co",
+	"CPP":      "$(CC) -E",
+	"CXX":      "g++",
+	"FC":       "f77",
+	"GET":      "get",
+	"LD":       "ld",
+	"LEX":      "lex",
+	"MAKE":     "make",
+	"MAKEINFO": "makeinfo",
+	"PC":       "pc",
+	"RM":       "rm -f",
+	"SHELL":    "/bin/sh",
+	"TEX":      "tex",
+	"YACC":     "yacc",
+}
+
+var (
+	makeAssignment = regexp.MustCompile(`^(?:export\s+|override\s+)?([A-Za-z_][A-Za-z0-9_.]*)\s*(\?=|:::=|::=|:=|\+=|!=|=)\s*(.*)$`)
+	makeVarRef     = regexp.MustCompile(`\$[({]([A-Za-z_][A-Za-z0-9_.]*)[)}]`)
+)
+
+func resolveMake(args []string, ctx contextinfo.Info) ([]recipe, bool) {
+	valueFlags := []string{"-C", "--directory", "-f", "--file", "--makefile", "-I", "--include-dir", "-o", "--old-file", "--assume-old", "-W", "--what-if", "--new-file", "--assume-new", "-l", "--load-average"}
+	noExec := []string{"-n", "--just-print", "--dry-run", "--recon", "-q", "--question", "-v", "--version", "-h", "--help"}
+	opts, positional, ok := runnerArgs(args, valueFlags, noExec)
+	if !ok {
+		return nil, false
+	}
+	ctx = runnerContext(ctx, optionValue(opts, "-C", "--directory"))
+	path := runnerFile(ctx, optionValue(opts, "-f", "--file", "--makefile"), "GNUmakefile", "makefile", "Makefile")
+	if path == "" {
+		return nil, false
+	}
+	mf, err := parseMakefile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	targets := []string{}
+	for _, word := range positional {
+		if eq := strings.Index(word, "="); eq > 0 {
+			mf.vars[word[:eq]] = word[eq+1:]
+			continue
+		}
+		if strings.Trim(word, "0123456789") == "" {
+			// The optional job count of "-j 4".
+			continue
+		}
+		targets = append(targets, word)
+	}
+	if len(targets) == 0 {
+		if mf.first == "" {
+			return nil, false
+		}
+		targets = []string{mf.first}
+	}
+
+	out := []recipe{}
+	seen := map[string]bool{}
+	var visit func(string)
+	visit = func(target string) {
+		if seen[target] {
+			return
+		}
+		seen[target] = true
+		rule, ok := mf.rules[target]
+		if !ok {
+			return
+		}
+		for _, dep := range rule.deps {
+			dep, _ = mf.expand(dep, target, ctx)
+			visit(dep)
+		}
+		for _, r := range rule.lines {
+			text, unresolved := mf.expand(r.text, target, ctx)
+			for _, name := range unresolved {
+				r.signals = append(r.signals, "unresolved make variable: "+name)
+			}
+			if words := strings.Fields(text); len(words) == 0 || strings.HasPrefix(words[0], "-") {
+				// "$(RM) -r ~" with RM unset runs "-r ~"; judge it as unknown.
+				r.signals = append(r.signals, "make variable leaves recipe without a command")
+			}
+			r.text = text
+			out = append(out, r)
+		}
+	}
+	for _, t := range targets {
+		if _, ok := mf.rules[t]; !ok {
+			return nil, false
+		}
+		visit(t)
+	}
+	return out, true
+}
+
+// parseMakefile reads rules, recipe lines and simple variable definitions.
+// Conditionals and includes are not interpreted.
+func parseMakefile(path string) (makefile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return makefile{}, err
+	}
+	mf := makefile{path: path, vars: map[string]string{}, rules: map[string]*makeRule{}}
+	dir := filepath.Dir(path)
+	var current []string
+
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		start := i + 1
+		line := strings.TrimRight(lines[i], "\r")
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, "\\") + "\n" + strings.TrimRight(lines[i], "\r")
+		}
+
+		if strings.HasPrefix(line, "\t") {
+			if len(current) == 0 {
+				continue
+			}
+			text := strings.TrimLeft(strings.TrimSpace(line), "@-+")
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			for _, t := range current {
+				mf.rules[t].lines = append(mf.rules[t].lines, recipe{target: t, file: path, line: start, text: strings.TrimSpace(text), dir: dir})
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		switch strings.SplitN(trimmed, " ", 2)[0] {
+		case "ifeq", "ifneq", "ifdef", "ifndef", "else", "endif":
+			// Both branches of a conditional are inspected.
+			continue
+		case "define":
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "endef" {
+				i++
+			}
+			i++
+			continue
+		}
+		current = nil
+		if m := makeAssignment.FindStringSubmatch(trimmed); m != nil {
+			if m[2] == "+=" && mf.vars[m[1]] != "" {
+				mf.vars[m[1]] += " " + m[3]
+			} else if m[2] != "?=" || mf.vars[m[1]] == "" {
+				mf.vars[m[1]] = m[3]
+			}
+			continue
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon <= 0 {
+			continue
+		}
+		targetPart := trimmed[:colon]
+		depPart := strings.TrimLeft(trimmed[colon+1:], ":")
+		inline := ""
+		if semi := strings.Index(depPart, ";"); semi >= 0 {
+			depPart, inline = depPart[:semi], strings.TrimSpace(depPart[semi+1:])
+		}
+		deps := []string{}
+		for _, d := range strings.Fields(depPart) {
+			if d != "|" {
+				deps = append(deps, d)
+			}
+		}
+		for _, t := range strings.Fields(targetPart) {
+			if strings.Contains(t, "%") {
+				continue
+			}
+			rule, ok := mf.rules[t]
+			if !ok {
+				rule = &makeRule{}
+				mf.rules[t] = rule
+			}
+			rule.deps = append(rule.deps, deps...)
+			if inline != "" {
+				rule.lines = append(rule.lines, recipe{target: t, file: path, line: start, text: strings.TrimLeft(inline, "@-+"), dir: dir})
+			}
+			if mf.first == "" && !strings.HasPrefix(t, ".") {
+				mf.first = t
+			}
+			current = append(current, t)
+		}
+	}
+	return mf, nil
+}
+
+// expand substitutes make variables the way make would before handing the
+// line to the shell: makefile and command-line variables first, then the
+// environment, then make's built-in variables. Variables found nowhere
+// expand to nothing and are returned; function calls such as $(shell ...)
+// are left in place.
+func (mf makefile) expand(s, target string, ctx contextinfo.Info) (string, []string) {
+	const dollar = "\x00"
+	var unresolved []string
+	s = strings.ReplaceAll(s, "$$", dollar)
+	s = strings.ReplaceAll(s, "$@", target)
+	for i := 0; i < 8 && makeVarRef.MatchString(s); i++ {
+		s = makeVarRef.ReplaceAllStringFunc(s, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			if v, ok := mf.vars[name]; ok {
+				return v
+			}
+			if v, ok := ctx.LookupEnv(name); ok {
+				return v
+			}
+			if v, ok := makeBuiltinVars[name]; ok {
+				return v
+			}
+			unresolved = appendUnique(unresolved, name)
+			return ""
+		})
+	}
+	return strings.ReplaceAll(s, dollar, "$"), unresolved
+}
+
+// --- npm, yarn, pnpm, bun ---
+
+// packageManagerCommands are built-in subcommands that never run a script of
+// the same name.
+var packageManagerCommands = map[string]bool{
+	"install": true, "i": true, "add": true, "remove": true, "rm": true, "uninstall": true,
+	"upgrade": true, "up": true, "update": true, "init": true, "create": true, "publish": true,
+	"link": true, "unlink": true, "pack": true, "info": true, "why": true, "config": true,
+	"cache": true, "exec": true, "dlx": true, "x": true,
+}
+
+func resolvePackageScripts(args []string, ctx contextinfo.Info) ([]recipe, bool) {
+	valueFlags := []string{"--prefix", "-C", "--dir", "--cwd", "-w", "--workspace", "--filter", "-F"}
+	opts, positional, ok := runnerArgs(args, valueFlags, []string{"-v", "--version", "-h", "--help"})
+	if !ok || len(positional) == 0 {
+		return nil, false
+	}
+	ctx = runnerContext(ctx, optionValue(opts, "--prefix", "-C", "--dir", "--cwd"))
+
+	name := ""
+	switch cmd := positional[0]; {
+	case cmd == "run" || cmd == "run-script" || cmd == "rum" || cmd == "urn":
+		if len(positional) < 2 {
+			return nil, false
+		}
+		name = positional[1]
+	case cmd == "test" || cmd == "t" || cmd == "tst":
+		name = "test"
+	case cmd == "start" || cmd == "stop" || cmd == "restart":
+		name = cmd
+	case args[0] != "npm" && !packageManagerCommands[cmd]:
+		// yarn, pnpm and bun run scripts named directly (yarn build).
+		name = cmd
+	default:
+		return nil, false
+	}
+
+	path := findRunnerFile(ctx, "package.json")
+	if path == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, false
+	}
+	if _, ok := pkg.Scripts[name]; !ok {
+		return nil, false
+	}
+	out := []recipe{}
+	// Lifecycle hooks run around the script (npm and yarn classic).
+	for _, script := range []string{"pre" + name, name, "post" + name} {
+		text, ok := pkg.Scripts[script]
+		if !ok {
+			continue
+		}
+		out = append(out, recipe{target: script, file: path, line: jsonKeyLine(data, script), text: text, dir: filepath.Dir(path)})
+	}
+	return out, true
+}
+
+// jsonKeyLine finds the line of a key inside the "scripts" object.
+func jsonKeyLine(data []byte, key string) int {
+	text := string(data)
+	offset := strings.Index(text, `"scripts"`)
+	if offset < 0 {
+		offset = 0
+	}
+	i := strings.Index(text[offset:], `"`+key+`"`)
+	if i < 0 {
+		return 0
+	}
+	return strings.Count(text[:offset+i], "\n") + 1
+}
+
+// --- just ---
+
+type justRecipe struct {
+	deps  []string
+	lines []recipe
+}
+
+var justHeader = regexp.MustCompile(`^@?([A-Za-z_][A-Za-z0-9_-]*)([^:]*):([^=].*|)$`)
+
+func resolveJust(args []string, ctx contextinfo.Info) ([]recipe, bool) {
+	valueFlags := []string{"-f", "--justfile", "-d", "--working-directory", "--set", "--shell", "--dotenv-filename", "--dotenv-path", "--color", "--command-color"}
+	noExec := []string{"-n", "--dry-run", "-l", "--list", "--summary", "--show", "-s", "--evaluate", "--dump", "--choose", "--edit", "--init", "--fmt", "--variables", "-h", "--help", "-V", "--version"}
+	opts, positional, ok := runnerArgs(args, valueFlags, noExec)
+	if !ok {
+		return nil, false
+	}
+	path := runnerFile(ctx, optionValue(opts, "-f", "--justfile"), "justfile", "Justfile", ".justfile")
+	if path == "" {
+		return nil, false
+	}
+	recipes, first, err := parseJustfile(path, optionValue(opts, "-d", "--working-directory"))
+	if err != nil {
+		return nil, false
+	}
+
+	targets := []string{}
+	for _, word := range positional {
+		if _, ok := recipes[word]; ok {
+			targets = append(targets, word)
+		}
+	}
+	if len(positional) == 0 {
+		if first == "" {
+			return nil, false
+		}
+		targets = []string{first}
+	}
+	if len(targets) == 0 {
+		return nil, false
+	}
+
+	out := []recipe{}
+	seen := map[string]bool{}
+	var visit func(string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		r, ok := recipes[name]
+		if !ok {
+			return
+		}
+		for _, dep := range r.deps {
+			visit(dep)
+		}
+		out = append(out, r.lines...)
+	}
+	for _, t := range targets {
+		visit(t)
+	}
+	return out, true
+}
+
+// parseJustfile reads recipe headers, dependencies and bodies. Shebang
+// recipes become a single recipe in the shebang's language.
+func parseJustfile(path, workdir string) (map[string]*justRecipe, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	dir := filepath.Dir(path)
+	if workdir != "" {
+		dir = workdir
+	}
+
+	recipes := map[string]*justRecipe{}
+	first := ""
+	var current *justRecipe
+	var currentName string
+	var shebang *recipe
+	flush := func() {
+		if current != nil && shebang != nil {
+			current.lines = append(current.lines, *shebang)
+		}
+		shebang = nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if current != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			text := strings.TrimSpace(line)
+			switch {
+			case shebang != nil:
+				shebang.text += "\n" + text
+			case strings.HasPrefix(text, "#!"):
+				shebang = &recipe{target: currentName, file: path, line: lineNo + 1, lang: shebangLine(text), dir: dir}
+			case text == "" || strings.HasPrefix(text, "#"):
+			default:
+				current.lines = append(current.lines, recipe{target: currentName, file: path, line: lineNo, text: strings.TrimLeft(text, "@-"), dir: dir})
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		flush()
+		current = nil
+		m := justHeader.FindStringSubmatch(line)
+		if m == nil || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := m[1]
+		if name == "alias" || name == "set" || name == "export" || name == "import" || name == "mod" {
+			continue
+		}
+		current = &justRecipe{deps: justDeps(m[3])}
+		currentName = name
+		recipes[name] = current
+		if first == "" {
+			first = name
+		}
+	}
+	flush()
+	return recipes, first, scanner.Err()
+}
+
+// justDeps extracts dependency names from a recipe header: plain names,
+// parenthesised calls with arguments ((build "x")) and those after &&.
+func justDeps(s string) []string {
+	if hash := strings.Index(s, "#"); hash >= 0 {
+		s = s[:hash]
+	}
+	deps := []string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '(' {
+			end := strings.Index(s, ")")
+			if end < 0 {
+				end = len(s)
+			}
+			if f := strings.Fields(s[1:end]); len(f) > 0 {
+				deps = append(deps, f[0])
+			}
+			s = s[min(end+1, len(s)):]
+			continue
+		}
+		word := s
+		if i := strings.IndexAny(s, " \t("); i >= 0 {
+			word = s[:i]
+		}
+		s = s[len(word):]
+		if word != "&&" {
+			deps = append(deps, word)
+		}
+	}
+	return deps
+}
+
+// --- task (go-task) ---
+
+func resolveTask(args []string, ctx contextinfo.Info) ([]recipe, bool) {
+	valueFlags := []string{"-d", "--dir", "-t", "--taskfile", "-o", "--output", "-c", "--concurrency", "-I", "--interval"}
+	noExec := []string{"-n", "--dry", "-l", "--list", "-a", "--list-all", "--summary", "-i", "--init", "-h", "--help", "--version", "-s", "--status"}
+	opts, positional, ok := runnerArgs(args, valueFlags, noExec)
+	if !ok {
+		return nil, false
+	}
+	ctx = runnerContext(ctx, optionValue(opts, "-d", "--dir"))
+	path := runnerFile(ctx, optionValue(opts, "-t", "--taskfile"), "Taskfile.yml", "taskfile.yml", "Taskfile.yaml", "taskfile.yaml", "Taskfile.dist.yml", "Taskfile.dist.yaml")
+	if path == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var tf struct {
+		Tasks map[string]yaml.Node `yaml:"tasks"`
+	}
+	if err := yaml.Unmarshal(data, &tf); err != nil {
+		return nil, false
+	}
+
+	targets := []string{}
+	for _, word := range positional {
+		if !strings.Contains(word, "=") {
+			targets = append(targets, word)
+		}
+	}
+	if len(targets) == 0 {
+		targets = []string{"default"}
+	}
+
+	out := []recipe{}
+	seen := map[string]bool{}
+	var visit func(string) bool
+	visit = func(name string) bool {
+		if seen[name] {
+			return true
+		}
+		seen[name] = true
+		node, ok := tf.Tasks[name]
+		if !ok {
+			return false
+		}
+		dir := filepath.Dir(path)
+		var cmds, deps []*yaml.Node
+		switch node.Kind {
+		case yaml.ScalarNode:
+			cmds = []*yaml.Node{&node}
+		case yaml.SequenceNode:
+			cmds = node.Content
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i].Value, node.Content[i+1]
+				switch key {
+				case "cmds":
+					cmds = value.Content
+				case "cmd":
+					cmds = []*yaml.Node{value}
+				case "deps":
+					deps = value.Content
+				case "dir":
+					if filepath.IsAbs(value.Value) {
+						dir = value.Value
+					} else {
+						dir = filepath.Join(dir, value.Value)
+					}
+				}
+			}
+		}
+		for _, dep := range deps {
+			visit(taskRef(dep))
+		}
+		for _, cmd := range cmds {
+			if cmd.Kind == yaml.MappingNode {
+				if ref := taskRef(cmd); ref != "" {
+					visit(ref)
+					continue
+				}
+				cmd = mappingValue(cmd, "cmd", "defer")
+				if cmd == nil {
+					continue
+				}
+			}
+			if cmd.Kind == yaml.ScalarNode && cmd.Value != "" {
+				out = append(out, recipe{target: name, file: path, line: cmd.Line, text: cmd.Value, dir: dir})
+			}
+		}
+		return true
+	}
+	for _, t := range targets {
+		if !visit(t) {
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// taskRef returns the task called by a deps entry or a {task: name} command.
+func taskRef(n *yaml.Node) string {
+	if n.Kind == yaml.ScalarNode {
+		return n.Value
+	}
+	if v := mappingValue(n, "task"); v != nil {
+		return v.Value
+	}
+	return ""
+}
+
+func mappingValue(n *yaml.Node, keys ...string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if containsString(keys, n.Content[i].Value) {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
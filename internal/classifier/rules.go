@@ -0,0 +1,247 @@
+package classifier
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+	"clash/internal/preview"
+)
+
+// matchRule returns the policy rule that decides a normalized argv: locked
+// rules first, then by descending priority, then in policy order. pr is nil
+// until the preview has run.
+func matchRule(args []string, ctx contextinfo.Info, p policy.Policy, pr *preview.Result) (policy.Rule, bool) {
+	if len(p.Rules) == 0 {
+		return policy.Rule{}, false
+	}
+	rules := append([]policy.Rule{}, p.Rules...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Locked != rules[j].Locked {
+			return rules[i].Locked
+		}
+		return rules[i].Priority > rules[j].Priority
+	})
+	for _, r := range rules {
+		if !ruleMatches(r, args, ctx, p) {
+			continue
+		}
+		if r.Match.When == "" {
+			return r, true
+		}
+		if pr == nil && r.Action == policy.RuleAllow && usesPreview(r.Match.When) {
+			// Before the preview has run its fields are zero; an allow rule
+			// on them would match too early and the preview never run.
+			continue
+		}
+		ok, err := evalCondition(r.Match.When, args, ctx, p, pr)
+		if err != nil {
+			// A broken condition fails closed: the rule applies, and an
+			// allow rule asks for confirmation instead.
+			if r.Action == policy.RuleAllow {
+				r.Action = policy.RuleConfirm
+			}
+			r.Reason = "condition error: " + err.Error()
+			return r, true
+		}
+		if ok {
+			return r, true
+		}
+	}
+	return policy.Rule{}, false
+}
+
+// ApplyPreviewRules checks the rules again for the previewed command now
+// that the preview counts are known, for conditions that use preview.
+func ApplyPreviewRules(res Result, hint preview.Hint, pr preview.Result, ctx contextinfo.Info, p policy.Policy) Result {
+	if len(hint.Args) == 0 {
+		return res
+	}
+	if rule, ok := matchRule(hint.Args, ctx, p, &pr); ok {
+		res = applyRule(res, rule)
+	}
+	return res
+}
+
+func ruleMatches(r policy.Rule, args []string, ctx contextinfo.Info, p policy.Policy) bool {
+	m := r.Match
+	if len(m.Command) > 0 && !containsFold(m.Command, args[0]) {
+		return false
+	}
+	if len(m.Subcommand) > 0 && !containsFold(m.Subcommand, subcommand(args)) {
+		return false
+	}
+	if len(m.FlagsPresent) > 0 || len(m.FlagsAbsent) > 0 {
+		spec, rest, _ := lookupArgSpec(args, p)
+		parsed := parseArgs(rest, spec)
+		has := func(flag string) bool {
+			if strings.HasPrefix(flag, "--") {
+				flag = expandLong(spec, flag)
+			}
+			return parsed.has(canonicalFlag(spec, flag))
+		}
+		for _, f := range m.FlagsPresent {
+			if !has(f) {
+				return false
+			}
+		}
+		for _, f := range m.FlagsAbsent {
+			if has(f) {
+				return false
+			}
+		}
+	}
+	if m.Argv != "" {
+		if ok, _ := regexp.MatchString(m.Argv, strings.Join(args, " ")); !ok {
+			return false
+		}
+	}
+	if len(m.Targets) > 0 && !targetsMatch(m.Targets, pathTargets(operandsFor(args, p), false), r.Action == policy.RuleAllow, ctx) {
+		return false
+	}
+	if m.InRepo != nil && *m.InRepo != ctx.InRepo {
+		return false
+	}
+	if m.OutsideRepo != nil && *m.OutsideRepo != isOutsideRepo(pathTargets(operandsFor(args, p), true), ctx) {
+		return false
+	}
+	if m.GitDirty != nil && *m.GitDirty != (ctx.Git.Changed > 0 || ctx.Git.Untracked > 0) {
+		return false
+	}
+	for name, pattern := range m.Env {
+		if ok, _ := regexp.MatchString("^(?:"+pattern+")$", ctx.Getenv(name)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// CommandFacts describes a normalized argv the way rule conditions, detector
+// plugins and Starlark scripts see it.
+type CommandFacts struct {
+	Name       string   `json:"name"`
+	Subcommand string   `json:"subcommand"`
+	Argv       []string `json:"argv"`
+	Args       []string `json:"args"`
+	Flags      []string `json:"flags"`
+	Operands   []string `json:"operands"`
+	Targets    []string `json:"targets"`
+	Writes     []string `json:"writes"`
+}
+
+// DescribeCommand parses a normalized argv with the policy's arg specs.
+func DescribeCommand(args []string, p policy.Policy) CommandFacts {
+	spec, rest, _ := lookupArgSpec(args, p)
+	parsed := parseArgs(rest, spec)
+	flags := make([]string, 0, len(parsed.flags))
+	for f := range parsed.flags {
+		flags = append(flags, f)
+	}
+	sort.Strings(flags)
+	operands := operandsFor(args, p)
+	return CommandFacts{
+		Name:       args[0],
+		Subcommand: subcommand(args),
+		Argv:       args,
+		Args:       args[1:],
+		Flags:      flags,
+		Operands:   append([]string{}, parsed.operands...),
+		Targets:    nonNil(pathTargets(operands, false)),
+		Writes:     nonNil(pathTargets(operands, true)),
+	}
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// subcommand is the first non-option argument.
+func subcommand(args []string) string {
+	for _, a := range args[1:] {
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			return a
+		}
+	}
+	return ""
+}
+
+// targetsMatch reports whether any target matches one of the globs, using
+// protected_paths matching.
+// targetsMatch reports whether the path targets match the globs. Block and
+// confirm rules match when any target does; allow rules only when every
+// target does, so one matching path cannot carry others along.
+func targetsMatch(globs []string, targets []string, all bool, ctx contextinfo.Info) bool {
+	if len(targets) == 0 {
+		return false
+	}
+	for _, t := range targets {
+		if targetMatches(globs, t, ctx) != all {
+			return !all
+		}
+	}
+	return all
+}
+
+func targetMatches(globs []string, target string, ctx contextinfo.Info) bool {
+	resolved, err := contextinfo.ResolvePath(ctx.Cwd, target)
+	if err != nil {
+		return false
+	}
+	for _, g := range globs {
+		if protectedMatches(policy.ProtectedPath{Path: g}, resolved, ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRule folds a matched rule into the built-in result. Confirm and block
+// rules can only make the decision stricter; an allow rule works like an
+// allow_commands entry and clears a CONFIRM but never a BLOCK.
+func applyRule(res Result, r policy.Rule) Result {
+	reason := "rule:" + r.ID
+	if r.Reason != "" {
+		reason += ": " + r.Reason
+	}
+	res.Rules = appendUnique(res.Rules, r.ID)
+	ruled := Result{Reasons: []string{reason}, SaferAlternative: r.SaferAlternative, Rules: res.Rules}
+	switch r.Action {
+	case policy.RuleAllow:
+		if res.Decision == DecisionBlock {
+			return res
+		}
+		ruled.Decision = DecisionAllow
+		ruled.Executable = res.Executable
+		ruled.Scripts = res.Scripts
+		return ruled
+	case policy.RuleConfirm:
+		ruled.Decision = DecisionConfirm
+	case policy.RuleBlock:
+		ruled.Decision = DecisionBlock
+	case policy.RuleHardBlock:
+		ruled.Decision = DecisionBlock
+		ruled.Hard = true
+	}
+	out := combine([]Result{res, ruled})
+	if out.SaferAlternative == "" && strictness(ruled) == strictness(out) {
+		out.SaferAlternative = r.SaferAlternative
+	}
+	return out
+}
@@ -0,0 +1,216 @@
+package classifier
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// maxScriptSize bounds how much of a script file is read for inspection.
+const maxScriptSize = 256 << 10
+
+// Script records a script file inspected before execution.
+type Script struct {
+	Path     string
+	SHA256   string
+	Findings []ScriptFinding
+}
+
+// ScriptFinding is a script line that raised the decision above ALLOW.
+type ScriptFinding struct {
+	Line     int
+	Text     string
+	Decision DecisionType
+	Signals  []string
+	Reasons  []string
+}
+
+// scriptValueFlags are interpreter options that consume the next argument
+// and so must be skipped when looking for the script operand.
+var scriptValueFlags = map[string][]string{
+	"shell":  {"-o", "+o", "-O", "+O", "--rcfile", "--init-file"},
+	"python": {"-W", "-X", "-Q"},
+	"node":   {"-r", "--require", "--import", "--loader", "--experimental-loader", "--input-type"},
+	"perl":   {"-I", "-M", "-m"},
+	"ruby":   {"-I", "-r", "-C", "-E"},
+	"php":    {"-c", "-d", "-z"},
+}
+
+// scriptArg returns the script file run by an interpreter invocation such as
+// "bash ./deploy.sh" or "python cleanup.py", together with its language.
+func scriptArg(args []string) (string, string, bool) {
+	name := args[0]
+	lang := interpreterLang(name)
+	switch {
+	case name == "source" || name == ".":
+		if len(args) > 1 {
+			return "shell", args[1], true
+		}
+		return "", "", false
+	case shellNames[name]:
+		lang = "shell"
+	case lang == "":
+		return "", "", false
+	}
+
+	valueFlags := scriptValueFlags[lang]
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			if i+1 < len(args) {
+				return lang, args[i+1], true
+			}
+			return "", "", false
+		case a == "-":
+			// Program read from stdin.
+			return "", "", false
+		case lang == "python" && a == "-m":
+			return "", "", false
+		case lang == "php" && a == "-f" && i+1 < len(args):
+			return lang, args[i+1], true
+		case lang == "shell" && a == "-s":
+			return "", "", false
+		case containsString(valueFlags, a):
+			i++
+		case strings.HasPrefix(a, "-") || (lang == "shell" && strings.HasPrefix(a, "+")):
+		default:
+			return lang, a, true
+		}
+	}
+	return "", "", false
+}
+
+// shebangLang reads the interpreter line of an executable script. It returns
+// "" for binaries and scripts in other languages.
+func shebangLang(path string) string {
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(io.LimitReader(f, 512)).ReadString('\n')
+	if err != nil && line == "" {
+		return ""
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+	return shebangLine(line)
+}
+
+// shebangLine maps a "#!" line to a language: "shell" for shells, an
+// interpreter from interpreterAPIs, or "" for anything else.
+func shebangLine(line string) string {
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "#!"))
+	for len(fields) > 0 {
+		base := filepath.Base(fields[0])
+		if base == "env" || strings.HasPrefix(base, "-") || strings.Contains(base, "=") {
+			fields = fields[1:]
+			continue
+		}
+		if shellNames[base] {
+			return "shell"
+		}
+		return interpreterLang(base)
+	}
+	return ""
+}
+
+// evaluateScript reads a script (within maxScriptSize), classifies each line
+// and records the file's SHA-256 so the approved content can be shown later.
+// Anything but a regular file, and scripts over maxScriptSize, are not read
+// and are a risk signal.
+func evaluateScript(lang, path string, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	resolved, err := contextinfo.ResolvePath(ctx.Cwd, path)
+	if err != nil {
+		return withSignals(Result{Decision: DecisionAllow}, "script could not be read: "+path)
+	}
+	// Stat before opening: opening a FIFO blocks until it has a writer.
+	info, err := os.Stat(resolved)
+	if errors.Is(err, fs.ErrNotExist) {
+		return withSignals(Result{Decision: DecisionAllow}, "script not found: "+path)
+	}
+	if err != nil {
+		return withSignals(Result{Decision: DecisionAllow}, "script could not be read: "+path)
+	}
+	if !info.Mode().IsRegular() {
+		return withSignals(Result{Decision: DecisionAllow}, "script is not a regular file: "+path)
+	}
+	if info.Size() > maxScriptSize {
+		return withSignals(Result{Decision: DecisionAllow}, "script too large to inspect: "+path)
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return withSignals(Result{Decision: DecisionAllow}, "script could not be read: "+path)
+	}
+	defer f.Close()
+
+	// The file may have grown since the stat; never read past the limit.
+	data, err := io.ReadAll(io.LimitReader(f, maxScriptSize+1))
+	if err != nil {
+		return withSignals(Result{Decision: DecisionAllow}, "script could not be read: "+path)
+	}
+	if len(data) > maxScriptSize {
+		return withSignals(Result{Decision: DecisionAllow}, "script too large to inspect: "+path)
+	}
+	sum := sha256.Sum256(data)
+	script := Script{Path: resolved, SHA256: hex.EncodeToString(sum[:])}
+	nest, ok := nest.entering(resolved)
+	if !ok {
+		// A script that sources itself, directly or through others.
+		res := withSignals(Result{Decision: DecisionAllow}, "script sources itself: "+path)
+		res.Scripts = []Script{script}
+		return res
+	}
+
+	src := string(data)
+	lines := strings.Split(src, "\n")
+	results := []lineResult{}
+	if lang == "shell" {
+		results, err = shellResults(src, ctx, p, nest)
+		if err != nil {
+			res := withSignals(Result{Decision: DecisionAllow}, "unparseable script: "+path)
+			res.Scripts = []Script{script}
+			return res
+		}
+	} else {
+		for _, finding := range scanCode(lang, src) {
+			results = append(results, lineResult{finding.Line, evaluateFinding(finding, ctx, p, nest)})
+		}
+	}
+
+	all := []Result{{Decision: DecisionAllow, Reasons: []string{"no risk signals"}}}
+	for _, r := range results {
+		all = append(all, r.result)
+		if r.result.Decision == DecisionAllow {
+			continue
+		}
+		text := ""
+		if r.line > 0 && r.line <= len(lines) {
+			text = strings.TrimSpace(lines[r.line-1])
+		}
+		script.Findings = append(script.Findings, ScriptFinding{
+			Line:     r.line,
+			Text:     text,
+			Decision: r.result.Decision,
+			Signals:  r.result.Signals,
+			Reasons:  r.result.Reasons,
+		})
+	}
+	res := combine(all)
+	res.Scripts = append([]Script{script}, res.Scripts...)
+	return res
+}
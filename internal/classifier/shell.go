@@ -0,0 +1,315 @@
+package classifier
+
+import (
+	"bytes"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// maxDepth bounds nested evaluation (shell payloads inside shell payloads).
+const maxDepth = 8
+
+// maxEvaluations bounds the commands classified for one Evaluate call, so
+// scripts that fan out into other scripts cannot grow it exponentially.
+const maxEvaluations = 4096
+
+// nesting is the state carried down nested evaluation.
+type nesting struct {
+	depth int
+	// scripts are the script files being evaluated, outermost first.
+	scripts []string
+	// evaluations counts the commands classified so far; it is shared by
+	// the whole Evaluate call.
+	evaluations *int
+}
+
+func newNesting() nesting {
+	return nesting{evaluations: new(int)}
+}
+
+// deeper returns the state for one more level of nesting.
+func (n nesting) deeper() nesting {
+	n.depth++
+	return n
+}
+
+// entering returns the state for evaluating a script, and false when the
+// script is already being evaluated further up the chain.
+func (n nesting) entering(script string) (nesting, bool) {
+	for _, s := range n.scripts {
+		if s == script {
+			return n, false
+		}
+	}
+	n.scripts = append(n.scripts[:len(n.scripts):len(n.scripts)], script)
+	return n, true
+}
+
+var shellNames = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "mksh": true, "ash": true,
+}
+
+// shellPayload returns the -c script passed to a shell, if any.
+func shellPayload(args []string) (string, bool) {
+	if len(args) < 2 || !shellNames[args[0]] {
+		return "", false
+	}
+	hasC := false
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			if hasC && i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		case a == "-o" || a == "+o" || a == "-O" || a == "+O" || a == "--rcfile" || a == "--init-file":
+			i++
+		case strings.HasPrefix(a, "--"):
+		case strings.HasPrefix(a, "-") || strings.HasPrefix(a, "+"):
+			if strings.Contains(a[1:], "c") {
+				hasC = true
+			}
+		default:
+			if hasC {
+				return a, true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// evaluateShell parses a shell script and evaluates every simple command and
+// output redirection it contains. The strictest decision wins.
+func evaluateShell(src string, ctx contextinfo.Info, p policy.Policy, nest nesting) Result {
+	lines, err := shellResults(src, ctx, p, nest)
+	if err != nil {
+		return Result{
+			Decision: DecisionConfirm,
+			Reasons:  []string{"shell payload could not be parsed"},
+			Signals:  []string{"unparseable shell payload"},
+		}
+	}
+	if len(lines) == 0 {
+		return Result{Decision: DecisionAllow, Reasons: []string{"no commands in shell payload"}}
+	}
+	results := make([]Result, 0, len(lines))
+	for _, l := range lines {
+		results = append(results, l.result)
+	}
+	return combine(results)
+}
+
+// lineResult is the classification of one command in a script.
+type lineResult struct {
+	line   int
+	result Result
+}
+
+// shellResults classifies each simple command and output redirection in a
+// shell script, in source order.
+func shellResults(src string, ctx contextinfo.Info, p policy.Policy, nest nesting) ([]lineResult, error) {
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(src), "")
+	if err != nil {
+		return nil, err
+	}
+
+	results := []lineResult{}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			argv := wordsToArgv(n.Args)
+			if len(argv) > 0 {
+				results = append(results, lineResult{int(n.Pos().Line()), evaluateCommand(argv, ctx, p, nest.deeper())})
+			}
+		case *syntax.Stmt:
+			for _, r := range n.Redirs {
+				if target, ok := redirectTarget(r); ok {
+					results = append(results, lineResult{int(r.Pos().Line()), evaluateRedirect(target, ctx, p)})
+				}
+			}
+		}
+		return true
+	})
+	return results, nil
+}
+
+// evaluateRedirect checks a file written by an output redirection.
+func evaluateRedirect(target string, ctx contextinfo.Info, p policy.Policy) Result {
+	switch target {
+	case "/dev/null", "/dev/stdout", "/dev/stderr", "/dev/tty":
+		return Result{Decision: DecisionAllow, Reasons: []string{"no risk signals"}}
+	}
+	signals := []string{}
+	targets := []string{target}
+	if hit, ok := findProtected(targets, ctx, p.ProtectedPaths); ok {
+		signals = append(signals, "redirect touches protected path")
+		if hit.blocks() {
+			return Result{Decision: DecisionBlock, Reasons: []string{hit.reason()}, Signals: signals}
+		}
+	}
+	if isOutsideRepo(targets, ctx) && !p.Options.AllowOutsideRepo {
+		signals = append(signals, "redirect outside repo root")
+	}
+	if len(signals) == 0 {
+		return Result{Decision: DecisionAllow, Reasons: []string{"no risk signals"}}
+	}
+	return Result{Decision: DecisionConfirm, Reasons: []string{"risk signals present"}, Signals: signals}
+}
+
+func redirectTarget(r *syntax.Redirect) (string, bool) {
+	if r.Word == nil {
+		return "", false
+	}
+	target := wordText(r.Word)
+	switch r.Op {
+	case syntax.RdrOut, syntax.AppOut, syntax.ClbOut, syntax.RdrAll, syntax.AppAll, syntax.RdrInOut:
+		return target, true
+	case syntax.DplOut:
+		// ">&file" writes to a file; ">&2" and ">&-" only duplicate descriptors.
+		if target == "-" || strings.Trim(target, "0123456789") == "" {
+			return "", false
+		}
+		return target, true
+	}
+	return "", false
+}
+
+func wordsToArgv(words []*syntax.Word) []string {
+	argv := make([]string, 0, len(words))
+	for _, w := range words {
+		argv = append(argv, wordText(w))
+	}
+	return argv
+}
+
+// wordText renders a word with quoting removed. Expansions that cannot be
+// resolved statically are kept in their source form (e.g. "$HOME").
+func wordText(w *syntax.Word) string {
+	var sb strings.Builder
+	for _, part := range w.Parts {
+		writeWordPart(&sb, part, false)
+	}
+	return sb.String()
+}
+
+func writeWordPart(sb *strings.Builder, part syntax.WordPart, quoted bool) {
+	switch x := part.(type) {
+	case *syntax.Lit:
+		sb.WriteString(unescape(x.Value, quoted))
+	case *syntax.SglQuoted:
+		sb.WriteString(x.Value)
+	case *syntax.DblQuoted:
+		for _, inner := range x.Parts {
+			writeWordPart(sb, inner, true)
+		}
+	default:
+		var buf bytes.Buffer
+		if err := syntax.NewPrinter().Print(&buf, part); err == nil {
+			sb.WriteString(buf.String())
+		}
+	}
+}
+
+// unescape removes shell backslash escapes. Inside double quotes only $, `,
+// ", \ and newline are escapable.
+func unescape(s string, quoted bool) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			next := s[i+1]
+			if !quoted || strings.IndexByte("$`\"\\\n", next) >= 0 {
+				if next != '\n' {
+					sb.WriteByte(next)
+				}
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// strictness orders decisions so results can be combined.
+func strictness(r Result) int {
+	switch r.Decision {
+	case DecisionBlock:
+		if r.Hard {
+			return 3
+		}
+		return 2
+	case DecisionConfirm:
+		return 1
+	}
+	return 0
+}
+
+// combine merges several results; the strictest decision wins and signals
+// from every part are kept.
+func combine(results []Result) Result {
+	strictest := results[0]
+	for _, r := range results[1:] {
+		if strictness(r) > strictness(strictest) {
+			strictest = r
+		}
+	}
+
+	out := strictest
+	out.Reasons = nil
+	out.Signals = nil
+	out.Scripts = nil
+	out.Rules = nil
+	for _, r := range results {
+		out.Signals = appendUnique(out.Signals, r.Signals...)
+		out.Rules = appendUnique(out.Rules, r.Rules...)
+		out.Scripts = appendScripts(out.Scripts, r.Scripts...)
+		if strictness(r) == strictness(strictest) {
+			out.Reasons = appendUnique(out.Reasons, r.Reasons...)
+		}
+		if out.PreviewHint == nil && r.Decision == DecisionConfirm {
+			out.PreviewHint = r.PreviewHint
+		}
+	}
+	return out
+}
+
+func appendScripts(list []Script, items ...Script) []Script {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing.Path == item.Path && existing.SHA256 == item.SHA256 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
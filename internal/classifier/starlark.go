@@ -0,0 +1,189 @@
+package classifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// starlarkModule is a loaded .star file: its evaluate function, or the
+// error from reading or running it.
+type starlarkModule struct {
+	evaluate starlark.Callable
+	err      error
+}
+
+var (
+	starlarkMu      sync.Mutex
+	starlarkModules = map[string]starlarkModule{}
+)
+
+// CompileStarlark loads a policy's .star file and checks that it defines
+// evaluate(cmd, ctx).
+func CompileStarlark(s policy.StarlarkScript) error {
+	return loadStarlark(s).err
+}
+
+// loadStarlark runs a script's top level once per file content. Scripts get
+// no load(), no I/O and no while loops or recursion, and top-level code is
+// bounded by the same step limit as each call.
+func loadStarlark(s policy.StarlarkScript) starlarkModule {
+	path := s.File()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return starlarkModule{err: err}
+	}
+	key := fmt.Sprintf("%s\x00%d\x00%s", path, s.Steps(), src)
+	starlarkMu.Lock()
+	defer starlarkMu.Unlock()
+	if m, ok := starlarkModules[key]; ok {
+		return m
+	}
+	thread := starlarkThread(s)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, path, src, nil)
+	var m starlarkModule
+	switch fn, ok := globals["evaluate"].(starlark.Callable); {
+	case err != nil:
+		m.err = err
+	case !ok:
+		m.err = fmt.Errorf("%s does not define evaluate(cmd, ctx)", path)
+	default:
+		globals.Freeze()
+		m.evaluate = fn
+	}
+	starlarkModules[key] = m
+	return m
+}
+
+func starlarkThread(s policy.StarlarkScript) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name:  "clash:" + s.Name,
+		Print: func(*starlark.Thread, string) {},
+	}
+	thread.SetMaxExecutionSteps(s.Steps())
+	return thread
+}
+
+// applyStarlark runs the policy's Starlark scripts for a normalized argv and
+// folds their answers into res. Like detectors, scripts can only make the
+// decision stricter, and a script that fails blocks the command.
+func applyStarlark(res Result, args []string, ctx contextinfo.Info, p policy.Policy) Result {
+	if len(p.Starlark) == 0 {
+		return res
+	}
+	var cmd, sctx starlark.Value
+	results := []Result{res}
+	for _, s := range p.Starlark {
+		if len(s.Match) > 0 && !containsFold(s.Match, args[0]) {
+			continue
+		}
+		if cmd == nil {
+			cmd, sctx = starlarkArgs(args, ctx, p)
+		}
+		results = append(results, runStarlark(s, cmd, sctx, args))
+	}
+	return combine(results)
+}
+
+// runStarlark calls a script's evaluate. It may return None (no opinion), a
+// decision string, or a dict with the fields of a detector response.
+func runStarlark(s policy.StarlarkScript, cmd, ctx starlark.Value, args []string) Result {
+	source := "starlark:" + s.Name
+	m := loadStarlark(s)
+	if m.err != nil {
+		return failClosed(source, "script", m.err)
+	}
+	out, err := starlark.Call(starlarkThread(s), m.evaluate, starlark.Tuple{cmd, ctx}, nil)
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			err = fmt.Errorf("%s", evalErr.Backtrace())
+		}
+		return failClosed(source, "script", err)
+	}
+	var resp DetectorResponse
+	switch v := out.(type) {
+	case starlark.NoneType:
+		return Result{Decision: DecisionAllow}
+	case starlark.String:
+		resp.Decision = string(v)
+	case *starlark.Dict:
+		data, err := starlark.Call(starlarkThread(s), starlarkjson.Module.Members["encode"], starlark.Tuple{v}, nil)
+		if err != nil {
+			return failClosed(source, "script", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader([]byte(data.(starlark.String))))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&resp); err != nil {
+			return failClosed(source, "script", fmt.Errorf("invalid result: %v", err))
+		}
+	default:
+		return failClosed(source, "script", fmt.Errorf("evaluate returned %s, want None, a string or a dict", out.Type()))
+	}
+	res, err := verdictResult(source, resp, args)
+	if err != nil {
+		return failClosed(source, "script", err)
+	}
+	return res
+}
+
+// starlarkArgs builds the frozen cmd and ctx values passed to evaluate. They
+// carry the same fields as rule conditions.
+func starlarkArgs(args []string, c contextinfo.Info, p policy.Policy) (starlark.Value, starlark.Value) {
+	facts := DescribeCommand(args, p)
+	cmd := starlarkstruct.FromStringDict(starlark.String("cmd"), starlark.StringDict{
+		"name":       starlark.String(facts.Name),
+		"subcommand": starlark.String(facts.Subcommand),
+		"argv":       starlarkStrings(facts.Argv),
+		"args":       starlarkStrings(facts.Args),
+		"flags":      starlarkStrings(facts.Flags),
+		"operands":   starlarkStrings(facts.Operands),
+		"targets":    starlarkStrings(facts.Targets),
+		"writes":     starlarkStrings(facts.Writes),
+	})
+	environ := environMap(c)
+	names := make([]string, 0, len(environ))
+	for k := range environ {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	env := starlark.NewDict(len(names))
+	for _, k := range names {
+		env.SetKey(starlark.String(k), starlark.String(environ[k]))
+	}
+	git := starlarkstruct.FromStringDict(starlark.String("git"), starlark.StringDict{
+		"changed":   starlark.MakeInt(c.Git.Changed),
+		"untracked": starlark.MakeInt(c.Git.Untracked),
+		"dirty":     starlark.Bool(c.Git.Changed > 0 || c.Git.Untracked > 0),
+		"branch":    starlark.String(c.Git.Branch),
+	})
+	ctx := starlarkstruct.FromStringDict(starlark.String("ctx"), starlark.StringDict{
+		"cwd":          starlark.String(c.Cwd),
+		"repo_root":    starlark.String(c.RepoRoot),
+		"in_repo":      starlark.Bool(c.InRepo),
+		"outside_repo": starlark.Bool(isOutsideRepo(facts.Writes, c)),
+		"env":          env,
+		"git":          git,
+	})
+	cmd.Freeze()
+	ctx.Freeze()
+	return cmd, ctx
+}
+
+func starlarkStrings(list []string) starlark.Tuple {
+	out := make(starlark.Tuple, len(list))
+	for i, s := range list {
+		out[i] = starlark.String(s)
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package classifier
+
+import (
+	"fmt"
+
+	"clash/internal/policy"
+	"clash/internal/preview"
+)
+
+// ThresholdHit records a preview count that exceeded a policy threshold.
+type ThresholdHit struct {
+	// Name is the threshold key, delete_count or modify_count.
+	Name   string
+	Limit  int
+	Count  int
+	Action string
+}
+
+// Phrase is what the user must type to approve a typed confirmation.
+func (h ThresholdHit) Phrase() string {
+	verb := "delete"
+	if h.Name == "modify_count" {
+		verb = "modify"
+	}
+	return fmt.Sprintf("%s %d items", verb, h.Count)
+}
+
+// ApplyThresholds escalates a CONFIRM whose preview count exceeds the
+// policy's delete_count or modify_count: either to a typed confirmation or to
+// a (soft) BLOCK, per thresholds.exceeded_action. Other decisions and
+// previews that failed are returned unchanged.
+func ApplyThresholds(res Result, hint preview.Hint, pr preview.Result, th policy.Thresholds) (Result, *ThresholdHit) {
+	if res.Decision != DecisionConfirm || pr.Err != "" {
+		return res, nil
+	}
+	hit := ThresholdHit{Name: "delete_count", Limit: th.DeleteCount, Count: pr.Count, Action: th.ExceededAction}
+	if hint.Kind == preview.HintModify {
+		hit.Name, hit.Limit = "modify_count", th.ModifyCount
+	}
+	if hit.Limit <= 0 || hit.Count <= hit.Limit {
+		return res, nil
+	}
+	if hit.Action != policy.ThresholdBlock {
+		hit.Action = policy.ThresholdTyped
+	}
+
+	reason := fmt.Sprintf("preview count %d exceeds %s %d", hit.Count, hit.Name, hit.Limit)
+	res.Signals = appendUnique(res.Signals, hit.Name+" exceeded")
+	if hit.Action == policy.ThresholdBlock {
+		res.Decision = DecisionBlock
+		res.Hard = false
+		res.Reasons = []string{reason}
+	} else {
+		res.Reasons = appendUnique(res.Reasons, reason)
+	}
+	return res, &hit
+}
@@ -0,0 +1,273 @@
+package classifier
+
+import (
+	"path/filepath"
+	"strings"
+
+	"clash/internal/contextinfo"
+)
+
+// wrapperSpec describes a prefix command that runs another command.
+type wrapperSpec struct {
+	// valueFlags consume a value, either attached (-u0, --user=0) or as the
+	// following argument.
+	valueFlags []string
+	// operands is the number of positional arguments before the wrapped
+	// command (timeout DURATION).
+	operands int
+	// assignments allows NAME=VALUE words before the command (env, sudo).
+	assignments bool
+	// elevates marks privilege escalation.
+	elevates bool
+	// shellFlags start an interactive shell when no command follows.
+	shellFlags []string
+	// noExecFlags mean the wrapper only inspects the command (command -v).
+	noExecFlags []string
+	// chdirFlags change the working directory of the command.
+	chdirFlags []string
+	// splitFlags take a string that is split into the command (env -S).
+	splitFlags []string
+}
+
+var wrappers = map[string]wrapperSpec{
+	"sudo": {
+		valueFlags:  []string{"-u", "--user", "-g", "--group", "-C", "--close-from", "-D", "--chdir", "-p", "--prompt", "-r", "--role", "-t", "--type", "-T", "--command-timeout", "-U", "--other-user", "-h", "--host"},
+		assignments: true,
+		elevates:    true,
+		shellFlags:  []string{"-s", "--shell", "-i", "--login"},
+		noExecFlags: []string{"-l", "--list", "-v", "--validate", "-k", "--reset-timestamp", "-K", "--remove-timestamp", "-V", "--version"},
+		chdirFlags:  []string{"-D", "--chdir"},
+	},
+	"doas": {
+		valueFlags: []string{"-u", "-C"},
+		elevates:   true,
+		shellFlags: []string{"-s"},
+	},
+	"pkexec": {
+		valueFlags: []string{"--user"},
+		elevates:   true,
+	},
+	"env": {
+		valueFlags:  []string{"-u", "--unset", "-C", "--chdir", "-S", "--split-string", "-P"},
+		assignments: true,
+		chdirFlags:  []string{"-C", "--chdir"},
+		splitFlags:  []string{"-S", "--split-string"},
+	},
+	"nice": {
+		valueFlags: []string{"-n", "--adjustment"},
+	},
+	"nohup": {},
+	"timeout": {
+		valueFlags: []string{"-s", "--signal", "-k", "--kill-after"},
+		operands:   1,
+	},
+	"xargs": {
+		valueFlags: []string{"-a", "--arg-file", "-d", "--delimiter", "-E", "-I", "-L", "-n", "--max-args", "-P", "--max-procs", "-s", "--max-chars", "--process-slot-var"},
+	},
+	"stdbuf": {
+		valueFlags: []string{"-i", "--input", "-o", "--output", "-e", "--error"},
+	},
+	"command": {
+		noExecFlags: []string{"-v", "-V"},
+	},
+	"exec": {
+		valueFlags: []string{"-a"},
+	},
+}
+
+// findExecActions are the find actions that run a command, which ends at
+// ";" or "+".
+var findExecActions = map[string]bool{"-exec": true, "-execdir": true, "-ok": true, "-okdir": true}
+
+// findExecCommands returns the commands run by find's -exec family of
+// actions, with "{}" standing for each path found.
+func findExecCommands(args []string) [][]string {
+	if args[0] != "find" {
+		return nil
+	}
+	var out [][]string
+	for i := 1; i < len(args); i++ {
+		if !findExecActions[args[i]] {
+			continue
+		}
+		j := i + 1
+		for j < len(args) && args[j] != ";" && args[j] != "+" {
+			j++
+		}
+		if j > i+1 {
+			out = append(out, args[i+1:j])
+		}
+		i = j
+	}
+	return out
+}
+
+// wrapped is the command a wrapper would run.
+type wrapped struct {
+	args    []string
+	ctx     contextinfo.Info
+	signals []string
+	// payload holds a shell script (su -c) instead of an argv.
+	payload string
+	// interactive marks a privileged shell started without a command.
+	interactive bool
+	// noExec marks wrappers that only inspect the command.
+	noExec bool
+}
+
+// unwrapWrapper extracts the real command from a prefix wrapper. The bool is
+// false when args[0] is not a known wrapper.
+func unwrapWrapper(args []string, ctx contextinfo.Info) (wrapped, bool) {
+	name := args[0]
+	if name == "su" {
+		return unwrapSu(args, ctx), true
+	}
+	spec, ok := wrappers[name]
+	if !ok {
+		return wrapped{}, false
+	}
+
+	out := wrapped{ctx: ctx}
+	if spec.elevates {
+		out.signals = append(out.signals, "privilege escalation via "+name)
+	}
+	if name == "xargs" {
+		out.signals = append(out.signals, "arguments supplied via xargs")
+	}
+
+	flags := []string{}
+	split := []string{}
+	operands := spec.operands
+	i := 1
+	for ; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			i++
+			break
+		}
+		if strings.HasPrefix(a, "-") && len(a) > 1 {
+			opt := parseWrapperOption(a, args[i+1:], spec.valueFlags)
+			flags = append(flags, opt.flags...)
+			i += opt.consumed
+			switch {
+			case containsString(spec.chdirFlags, opt.valueFlag):
+				dir := opt.value
+				if !filepath.IsAbs(dir) {
+					dir = filepath.Join(out.ctx.Cwd, dir)
+				}
+				out.ctx.Cwd = dir
+			case containsString(spec.splitFlags, opt.valueFlag):
+				split = strings.Fields(opt.value)
+			}
+			continue
+		}
+		if spec.assignments && isAssignment(a) {
+			continue
+		}
+		if operands > 0 {
+			operands--
+			continue
+		}
+		break
+	}
+	out.args = append(split, args[i:]...)
+
+	for _, f := range flags {
+		if containsString(spec.noExecFlags, f) {
+			out.noExec = true
+		}
+		if len(out.args) == 0 && containsString(spec.shellFlags, f) {
+			out.interactive = true
+		}
+	}
+	if name == "xargs" && len(out.args) == 0 {
+		out.args = []string{"echo"}
+	}
+	return out, true
+}
+
+// unwrapSu handles su [options] [-] [user] [args], where the command is a
+// shell string passed with -c.
+func unwrapSu(args []string, ctx contextinfo.Info) wrapped {
+	out := wrapped{ctx: ctx, signals: []string{"privilege escalation via su"}}
+	valueFlags := []string{"-c", "--command", "-s", "--shell", "-g", "--group", "-G", "--supp-group", "-w", "--whitelist-environment"}
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		if a == "-" || !strings.HasPrefix(a, "-") {
+			continue
+		}
+		opt := parseWrapperOption(a, args[i+1:], valueFlags)
+		i += opt.consumed
+		if opt.valueFlag == "-c" || opt.valueFlag == "--command" {
+			out.payload = opt.value
+		}
+	}
+	if out.payload == "" {
+		out.interactive = true
+	}
+	return out
+}
+
+// wrapperOption is one parsed option word.
+type wrapperOption struct {
+	flags     []string
+	valueFlag string
+	value     string
+	consumed  int
+}
+
+// parseWrapperOption parses one option word; rest holds the arguments that
+// follow it so a separate value can be consumed.
+func parseWrapperOption(a string, rest []string, valueFlags []string) wrapperOption {
+	if strings.HasPrefix(a, "--") {
+		if eq := strings.Index(a, "="); eq >= 0 {
+			return wrapperOption{flags: []string{a[:eq]}, valueFlag: a[:eq], value: a[eq+1:]}
+		}
+		if containsString(valueFlags, a) && len(rest) > 0 {
+			return wrapperOption{flags: []string{a}, valueFlag: a, value: rest[0], consumed: 1}
+		}
+		return wrapperOption{flags: []string{a}}
+	}
+	// Short options may be clustered (-Eu root); the first one taking a value
+	// ends the cluster.
+	opt := wrapperOption{}
+	for j := 1; j < len(a); j++ {
+		flag := "-" + string(a[j])
+		opt.flags = append(opt.flags, flag)
+		if !containsString(valueFlags, flag) {
+			continue
+		}
+		opt.valueFlag = flag
+		if j+1 < len(a) {
+			opt.value = a[j+1:]
+		} else if len(rest) > 0 {
+			opt.value = rest[0]
+			opt.consumed = 1
+		}
+		break
+	}
+	return opt
+}
+
+func isAssignment(a string) bool {
+	eq := strings.Index(a, "=")
+	if eq <= 0 {
+		return false
+	}
+	for i, r := range a[:eq] {
+		if r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
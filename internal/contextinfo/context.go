@@ -2,10 +2,13 @@ package contextinfo
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 // Info holds execution context for policy evaluation and logging.
@@ -14,12 +17,17 @@ type Info struct {
 	RepoRoot  string
 	InRepo    bool
 	Git       GitSummary
+	// Env is the environment the command will run with; nil means the
+	// current process environment.
+	Env       []string
 }
 
 // GitSummary captures a minimal git status snapshot.
 type GitSummary struct {
 	Changed  int
 	Untracked int
+	// Branch is the checked-out branch, empty when HEAD is detached.
+	Branch   string `json:",omitempty"`
 }
 
 // Detect collects cwd, repo root, and git status summary.
@@ -28,7 +36,32 @@ func Detect() (Info, error) {
 	if err != nil {
 		return Info{}, err
 	}
+	info := DetectAt(cwd)
+	info.Env = os.Environ()
+	return info, nil
+}
 
+// LookupEnv reads a variable from the command's environment.
+func (i Info) LookupEnv(key string) (string, bool) {
+	if i.Env == nil {
+		return os.LookupEnv(key)
+	}
+	for j := len(i.Env) - 1; j >= 0; j-- {
+		if name, value, ok := strings.Cut(i.Env[j], "="); ok && name == key {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Getenv reads a variable from the command's environment.
+func (i Info) Getenv(key string) string {
+	v, _ := i.LookupEnv(key)
+	return v
+}
+
+// DetectAt collects the same context for another working directory.
+func DetectAt(cwd string) Info {
 	repo, inRepo := findRepoRoot(cwd)
 	gitSum := GitSummary{}
 	if inRepo {
@@ -40,7 +73,29 @@ func Detect() (Info, error) {
 		RepoRoot: repo,
 		InRepo:   inRepo,
 		Git:      gitSum,
-	}, nil
+	}
+}
+
+// DetectWorkTree collects context for a git invocation with an explicit
+// work tree and/or git directory (--work-tree, --git-dir).
+func DetectWorkTree(cwd, workTree, gitDir string) Info {
+	if workTree == "" && gitDir == "" {
+		return DetectAt(cwd)
+	}
+	if workTree == "" {
+		// Without --work-tree git treats the working directory as the top.
+		workTree = cwd
+	}
+	globals := []string{"--work-tree=" + workTree}
+	if gitDir != "" {
+		globals = append(globals, "--git-dir="+gitDir)
+	}
+	return Info{
+		Cwd:      cwd,
+		RepoRoot: workTree,
+		InRepo:   true,
+		Git:      gitStatus(workTree, globals...),
+	}
 }
 
 func findRepoRoot(start string) (string, bool) {
@@ -60,8 +115,9 @@ func findRepoRoot(start string) (string, bool) {
 	}
 }
 
-func gitStatus(repo string) GitSummary {
-	cmd := exec.Command("git", "-C", repo, "status", "--porcelain")
+func gitStatus(repo string, globals ...string) GitSummary {
+	args := append([]string{"-C", repo}, globals...)
+	cmd := exec.Command("git", append(args, "status", "--porcelain")...)
 	output, err := cmd.Output()
 	if err != nil {
 		return GitSummary{}
@@ -79,7 +135,12 @@ func gitStatus(repo string) GitSummary {
 			changed++
 		}
 	}
-	return GitSummary{Changed: changed, Untracked: untracked}
+	branch := ""
+	cmd = exec.Command("git", append(args, "symbolic-ref", "--short", "-q", "HEAD")...)
+	if out, err := cmd.Output(); err == nil {
+		branch = strings.TrimSpace(string(out))
+	}
+	return GitSummary{Changed: changed, Untracked: untracked, Branch: branch}
 }
 
 // IsInsideRepo returns true if path is within repo root.
@@ -99,10 +160,18 @@ func IsInsideRepo(repoRoot, path string) bool {
 	if err != nil {
 		return false
 	}
-	return rel == "." || (!strings.HasPrefix(rel, ".."))
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
+// maxSymlinks bounds symlink expansion, as the kernel's ELOOP limit does.
+const maxSymlinks = 40
+
 // ResolvePath resolves candidate relative to base, handling ~ expansion.
+// Like realpath -m the path need not exist: symlinks are resolved component
+// by component along the existing prefix (so ".." after a symlink applies to
+// its target) and the missing remainder is joined lexically. An error means
+// the location cannot be determined, e.g. a symlink loop or an unreadable
+// directory.
 func ResolvePath(base, candidate string) (string, error) {
 	if candidate == "" {
 		return "", errors.New("empty path")
@@ -112,10 +181,58 @@ func ResolvePath(base, candidate string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		candidate = filepath.Join(home, strings.TrimPrefix(candidate, "~"))
+		candidate = home + string(filepath.Separator) + strings.TrimPrefix(candidate, "~")
 	}
 	if !filepath.IsAbs(candidate) {
-		candidate = filepath.Join(base, candidate)
+		// Not filepath.Join: it would apply ".." before symlinks are resolved.
+		candidate = base + string(filepath.Separator) + candidate
+	}
+
+	sep := string(filepath.Separator)
+	resolved := sep
+	rest := strings.Split(candidate, sep)
+	links := 0
+	missing := false
+	for len(rest) > 0 {
+		c := rest[0]
+		rest = rest[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, c)
+		if missing {
+			resolved = next
+			continue
+		}
+		info, err := os.Lstat(next)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+				missing = true
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", candidate)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = sep
+		}
+		rest = append(strings.Split(target, sep), rest...)
 	}
-	return filepath.EvalSymlinks(candidate)
+	return resolved, nil
 }
@@ -0,0 +1,305 @@
+// Package fixtures runs declarative policy tests: YAML files listing
+// commands, the context they run in and the decision the policy must reach.
+package fixtures
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"clash/internal/classifier"
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// File is a fixture file:
+//
+//	context:
+//	  repo_root: /work/app
+//	cases:
+//	  - name: rm of the home directory
+//	    argv: [rm, -rf, "~"]
+//	    expect: {decision: block, hard: true}
+//	  - name: build cleanup is confirmed
+//	    argv: [rm, -rf, build]
+//	    context: {git: {changed: 3}}
+//	    expect:
+//	      decision: confirm
+//	      signals: [recursive delete]
+type File struct {
+	Path    string  `yaml:"-"`
+	Context Context `yaml:"context,omitempty"`
+	Cases   []Case  `yaml:"cases"`
+}
+
+// Context describes the simulated environment of a case. Unset fields take
+// the file's context, then the defaults passed to Run.
+type Context struct {
+	Cwd      string            `yaml:"cwd,omitempty"`
+	RepoRoot string            `yaml:"repo_root,omitempty"`
+	InRepo   *bool             `yaml:"in_repo,omitempty"`
+	Git      Git               `yaml:"git,omitempty"`
+	Env      map[string]string `yaml:"env,omitempty"`
+}
+
+// Git is the simulated git status.
+type Git struct {
+	Changed   *int   `yaml:"changed,omitempty"`
+	Untracked *int   `yaml:"untracked,omitempty"`
+	Branch    string `yaml:"branch,omitempty"`
+}
+
+// Case is one command and what the policy must decide for it.
+type Case struct {
+	Name    string   `yaml:"name"`
+	Argv    []string `yaml:"argv"`
+	Context *Context `yaml:"context,omitempty"`
+	Expect  Expect   `yaml:"expect"`
+}
+
+// Expect lists the checks for a case. Decision is required; signals and rules
+// must all be present, and each reason must be a substring of some reason.
+type Expect struct {
+	Decision string   `yaml:"decision"`
+	Hard     *bool    `yaml:"hard,omitempty"`
+	Signals  []string `yaml:"signals,omitempty"`
+	Reasons  []string `yaml:"reasons,omitempty"`
+	Rules    []string `yaml:"rules,omitempty"`
+}
+
+// Outcome is the result of running one case.
+type Outcome struct {
+	File     string
+	Case     string
+	Argv     []string
+	Result   classifier.Result
+	Failures []string
+	Duration time.Duration
+}
+
+// Passed reports whether every expectation held.
+func (o Outcome) Passed() bool {
+	return len(o.Failures) == 0
+}
+
+// Load reads a fixture file, rejecting unknown keys.
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, err
+	}
+	var f File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return File{}, fmt.Errorf("%s: %w", path, err)
+	}
+	f.Path = path
+	for i, c := range f.Cases {
+		if c.Name == "" {
+			c.Name = strings.Join(c.Argv, " ")
+			f.Cases[i].Name = c.Name
+		}
+		if len(c.Argv) == 0 {
+			return File{}, fmt.Errorf("%s: case %q: argv is empty", path, c.Name)
+		}
+		if _, err := decision(c.Expect.Decision); err != nil {
+			return File{}, fmt.Errorf("%s: case %q: %w", path, c.Name, err)
+		}
+	}
+	return f, nil
+}
+
+// Find expands the given paths into fixture files: files are taken as they
+// are and directories contribute their *.yaml and *.yml files.
+func Find(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(p, pattern))
+			files = append(files, matches...)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// defaultEnv is the environment every case starts from. Nothing is taken
+// from the environment the tests run in, so $HOME, ~ and ${VAR} mean the same
+// on every machine.
+var defaultEnv = map[string]string{"HOME": "/home/clash", "PATH": "/usr/local/bin:/usr/bin:/bin"}
+
+// Run evaluates every case of f against p. defaults supplies the cwd and
+// repo root for cases that do not set them; git status and environment are
+// only what the fixture says, on top of defaultEnv.
+func Run(f File, p policy.Policy, defaults contextinfo.Info) []Outcome {
+	var out []Outcome
+	for _, c := range f.Cases {
+		ctx := f.Context
+		if c.Context != nil {
+			ctx = overlay(ctx, *c.Context)
+		}
+		start := time.Now()
+		res := classifier.Evaluate(c.Argv, ctx.info(defaults), p)
+		out = append(out, Outcome{
+			File:     f.Path,
+			Case:     c.Name,
+			Argv:     c.Argv,
+			Result:   res,
+			Failures: check(c.Expect, res),
+			Duration: time.Since(start),
+		})
+	}
+	return out
+}
+
+// overlay returns base with the fields set in top replacing its own.
+func overlay(base, top Context) Context {
+	if top.Cwd != "" {
+		base.Cwd = top.Cwd
+	}
+	if top.RepoRoot != "" {
+		base.RepoRoot = top.RepoRoot
+	}
+	if top.InRepo != nil {
+		base.InRepo = top.InRepo
+	}
+	if top.Git.Changed != nil {
+		base.Git.Changed = top.Git.Changed
+	}
+	if top.Git.Untracked != nil {
+		base.Git.Untracked = top.Git.Untracked
+	}
+	if top.Git.Branch != "" {
+		base.Git.Branch = top.Git.Branch
+	}
+	if len(top.Env) > 0 {
+		env := map[string]string{}
+		for k, v := range base.Env {
+			env[k] = v
+		}
+		for k, v := range top.Env {
+			env[k] = v
+		}
+		base.Env = env
+	}
+	return base
+}
+
+// info builds the synthetic contextinfo.Info for a case. A relative cwd is
+// taken relative to the repo root, and in_repo defaults to whether the cwd
+// is inside it.
+func (c Context) info(defaults contextinfo.Info) contextinfo.Info {
+	info := contextinfo.Info{Cwd: c.Cwd, RepoRoot: c.RepoRoot}
+	if info.RepoRoot == "" {
+		info.RepoRoot = defaults.RepoRoot
+	}
+	switch {
+	case info.Cwd == "" && c.RepoRoot != "":
+		info.Cwd = info.RepoRoot
+	case info.Cwd == "":
+		info.Cwd = defaults.Cwd
+	case !filepath.IsAbs(info.Cwd):
+		info.Cwd = filepath.Join(info.RepoRoot, info.Cwd)
+	}
+	if c.InRepo != nil {
+		info.InRepo = *c.InRepo
+	} else if rel, err := filepath.Rel(info.RepoRoot, info.Cwd); err == nil && info.RepoRoot != "" {
+		info.InRepo = rel == "." || (rel != ".." && !strings.HasPrefix(rel, "../"))
+	}
+	if c.Git.Changed != nil {
+		info.Git.Changed = *c.Git.Changed
+	}
+	if c.Git.Untracked != nil {
+		info.Git.Untracked = *c.Git.Untracked
+	}
+	info.Git.Branch = c.Git.Branch
+	env := map[string]string{}
+	for k, v := range defaultEnv {
+		env[k] = v
+	}
+	for k, v := range c.Env {
+		env[k] = v
+	}
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	info.Env = []string{}
+	for _, k := range names {
+		info.Env = append(info.Env, k+"="+env[k])
+	}
+	return info
+}
+
+func check(want Expect, got classifier.Result) []string {
+	var failures []string
+	if d, _ := decision(want.Decision); got.Decision != d {
+		failures = append(failures, fmt.Sprintf("decision: got %s, want %s", got.Decision, d))
+	}
+	if want.Hard != nil && got.Hard != *want.Hard {
+		failures = append(failures, fmt.Sprintf("hard: got %t, want %t", got.Hard, *want.Hard))
+	}
+	for _, s := range want.Signals {
+		if !contains(got.Signals, s) {
+			failures = append(failures, fmt.Sprintf("signal %q missing (got %s)", s, list(got.Signals)))
+		}
+	}
+	for _, r := range want.Reasons {
+		found := false
+		for _, reason := range got.Reasons {
+			if strings.Contains(reason, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("reason %q missing (got %s)", r, list(got.Reasons)))
+		}
+	}
+	for _, id := range want.Rules {
+		if !contains(got.Rules, id) {
+			failures = append(failures, fmt.Sprintf("rule %q did not match (got %s)", id, list(got.Rules)))
+		}
+	}
+	return failures
+}
+
+func decision(s string) (classifier.DecisionType, error) {
+	switch d := classifier.DecisionType(strings.ToUpper(s)); d {
+	case classifier.DecisionAllow, classifier.DecisionConfirm, classifier.DecisionBlock:
+		return d, nil
+	}
+	return "", fmt.Errorf("expect.decision %q is not allow, confirm or block", s)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func list(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, "; ")
+}
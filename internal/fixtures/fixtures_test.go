@@ -0,0 +1,120 @@
+package fixtures
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+func TestRunFixtures(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "cases.yaml")
+	data := `context:
+  repo_root: /work/app
+cases:
+  - name: home delete
+    argv: [rm, -rf, "~"]
+    expect: {decision: block, hard: true}
+  - name: wrong expectation
+    argv: [rm, -rf, build]
+    context: {cwd: src, git: {changed: 2}}
+    expect:
+      decision: allow
+      signals: [no such signal]
+  - argv: [ls]
+    expect: {decision: allow}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol, err := policy.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := Run(f, pol, contextinfo.Info{Cwd: tmp, RepoRoot: tmp})
+	if len(outcomes) != 3 {
+		t.Fatalf("expected 3 outcomes, got %d", len(outcomes))
+	}
+	if !outcomes[0].Passed() || !outcomes[2].Passed() {
+		t.Errorf("expected home delete and ls to pass, got %v and %v", outcomes[0].Failures, outcomes[2].Failures)
+	}
+	if outcomes[2].Case != "ls" {
+		t.Errorf("expected unnamed case to be named by its argv, got %q", outcomes[2].Case)
+	}
+	failures := strings.Join(outcomes[1].Failures, "\n")
+	if !strings.Contains(failures, "decision: got CONFIRM, want ALLOW") || !strings.Contains(failures, `signal "no such signal" missing`) {
+		t.Errorf("unexpected failures: %s", failures)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, outcomes); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{`tests="3" failures="1"`, `<testcase name="wrong expectation"`, `<failure message="decision: got CONFIRM, want ALLOW">`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("JUnit output missing %s:\n%s", want, out)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("cases:\n  - argv: [ls]\n    expect: {decision: maybe}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "not allow, confirm or block") {
+		t.Errorf("expected bad decision to be rejected, got %v", err)
+	}
+}
+
+func TestFixtureEnvironmentIsSynthetic(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLASH_FIXTURE_TARGET", "/etc/passwd")
+	f := File{
+		Context: Context{RepoRoot: "/work/app"},
+		Cases: []Case{
+			{Name: "ssh key", Argv: []string{"touch", "~/.ssh/config"}, Expect: Expect{Decision: "confirm", Signals: []string{"touches protected path"}}},
+			{Name: "host variable", Argv: []string{"touch", "${CLASH_FIXTURE_TARGET}.bak"}},
+			{Name: "fixture home", Argv: []string{"touch", "~/.ssh/config"}, Context: &Context{Env: map[string]string{"HOME": "/srv/ci"}}, Expect: Expect{Decision: "confirm", Signals: []string{"touches protected path"}}},
+		},
+	}
+	pol, err := policy.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := Run(f, pol, contextinfo.Info{})
+	if !outcomes[0].Passed() || !outcomes[2].Passed() {
+		t.Errorf("expected ~/.ssh to follow the fixture's HOME: %v %v", outcomes[0].Failures, outcomes[2].Failures)
+	}
+	if got := outcomes[1].Result.Signals; contains(got, "touches protected path") {
+		t.Errorf("host environment leaked into the fixture: %v", got)
+	}
+
+	info := f.Context.info(contextinfo.Info{})
+	if info.Getenv("HOME") != "/home/clash" || info.Getenv("CLASH_FIXTURE_TARGET") != "" {
+		t.Errorf("unexpected fixture environment %v", info.Env)
+	}
+}
+
+func TestExampleFixtures(t *testing.T) {
+	f, err := Load(filepath.Join("..", "..", "examples", "policy-tests.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol, err := policy.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range Run(f, pol, contextinfo.Info{}) {
+		if !o.Passed() {
+			t.Errorf("%s: %s", o.Case, strings.Join(o.Failures, "; "))
+		}
+	}
+}
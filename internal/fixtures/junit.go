@@ -0,0 +1,91 @@
+package fixtures
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes outcomes as JUnit XML, one test suite per fixture file.
+func WriteJUnit(w io.Writer, outcomes []Outcome) error {
+	doc := junitSuites{Name: "clash policy test"}
+	var total time.Duration
+	index := map[string]int{}
+	for _, o := range outcomes {
+		i, ok := index[o.File]
+		if !ok {
+			i = len(doc.Suites)
+			index[o.File] = i
+			doc.Suites = append(doc.Suites, junitSuite{Name: o.File})
+		}
+		s := &doc.Suites[i]
+		c := junitCase{Name: o.Case, Classname: o.File, Time: seconds(o.Duration)}
+		if !o.Passed() {
+			c.Failure = &junitFailure{
+				Message: o.Failures[0],
+				Text:    fmt.Sprintf("argv: %s\ndecision: %s\n%s", strings.Join(o.Argv, " "), o.Result.Decision, strings.Join(o.Failures, "\n")),
+			}
+			s.Failures++
+			doc.Failures++
+		}
+		s.Cases = append(s.Cases, c)
+		s.Tests++
+		doc.Tests++
+		total += o.Duration
+	}
+	for i := range doc.Suites {
+		var d time.Duration
+		for _, o := range outcomes {
+			if o.File == doc.Suites[i].Name {
+				d += o.Duration
+			}
+		}
+		doc.Suites[i].Time = seconds(d)
+	}
+	doc.Time = seconds(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
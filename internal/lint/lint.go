@@ -0,0 +1,193 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"clash/internal/classifier"
+	"clash/internal/policy"
+)
+
+// Severity grades a finding; errors fail `clash policy lint`.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is one problem in a policy.
+type Finding struct {
+	Severity Severity
+	// Source is the file or layer the offending value came from.
+	Source  string
+	Message string
+}
+
+func (f Finding) String() string {
+	if f.Source == "" {
+		return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Source, f.Severity, f.Message)
+}
+
+// HasErrors reports whether any finding is an error.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Layers checks each policy file on its own, then the policy they resolve
+// to. Missing files are skipped, as in resolution.
+func Layers(layers []policy.Layer) []Finding {
+	var findings []Finding
+	for _, l := range layers {
+		data, err := os.ReadFile(l.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = policy.Check(data)
+		}
+		if err != nil {
+			findings = append(findings, Finding{Severity: SeverityError, Source: l.Path, Message: err.Error()})
+		}
+	}
+	if len(findings) > 0 {
+		return findings
+	}
+	res, err := policy.LoadLayers(layers)
+	if err != nil {
+		return []Finding{{Severity: SeverityError, Message: err.Error()}}
+	}
+	return Resolution(res)
+}
+
+// Resolution reports semantic problems in an effective policy, attributing
+// each to the layer that set the value.
+func Resolution(res policy.Resolution) []Finding {
+	var findings []Finding
+	report := func(sev Severity, key, format string, args ...interface{}) {
+		source := ""
+		if l, ok := res.Sources[key]; ok {
+			source = l.String()
+		}
+		findings = append(findings, Finding{Severity: sev, Source: source, Message: fmt.Sprintf(format, args...)})
+	}
+	p := res.Policy
+
+	for _, w := range res.Warnings {
+		findings = append(findings, Finding{Severity: SeverityWarning, Message: w})
+	}
+
+	for _, a := range p.AllowCommands {
+		key := "allow_commands[" + a + "]"
+		name := strings.ToLower(strings.Fields(a + " ")[0])
+		for _, b := range p.BlockCommands {
+			switch {
+			case strings.EqualFold(a, b):
+				report(SeverityError, key, "%q is in both allow_commands and block_commands", a)
+			case strings.HasPrefix(name, strings.ToLower(b)):
+				report(SeverityWarning, key, "allow_commands entry %q never applies: block_commands entry %q blocks it", a, b)
+			}
+		}
+		for _, c := range classifier.HardBlockCommands {
+			if strings.EqualFold(a, c) || strings.HasPrefix(c, strings.ToLower(a)+" ") {
+				report(SeverityWarning, key, "allow_commands entry %q shadows the %q safety rules: only the hard-block cases are still caught, everything else runs without confirmation or preview", a, c)
+			}
+		}
+	}
+
+	for _, a := range p.ArgSpecs {
+		command := strings.Join(strings.Fields(a.Command), " ")
+		for _, c := range classifier.HardBlockCommands {
+			if strings.EqualFold(command, c) {
+				report(SeverityError, "arg_specs["+a.Command+"]", "arg_specs entry %q redefines a command with built-in hard-block rules; they always use the built-in grammar", a.Command)
+			}
+		}
+	}
+
+	negatable := false
+	for _, pp := range p.ProtectedPaths {
+		key := "protected_paths[" + pp.Path + "]"
+		if msg := unmatchable(pp, negatable); msg != "" {
+			report(SeverityWarning, key, "protected path %q can never match: %s", pp.Path, msg)
+		}
+		if !pp.Negated() {
+			negatable = true
+		}
+	}
+
+	for _, r := range p.Rules {
+		if r.Match.When == "" {
+			continue
+		}
+		if err := classifier.CompileCondition(r.Match.When); err != nil {
+			report(SeverityError, "rules["+r.ID+"]", "rule %s: when: %v", r.ID, err)
+		}
+	}
+
+	for _, d := range p.Detectors {
+		if _, err := exec.LookPath(d.Executable()); err != nil {
+			report(SeverityWarning, "detectors["+d.Name+"]", "detector %s: %s not found; commands it matches will be blocked", d.Name, d.Executable())
+		}
+	}
+
+	for _, s := range p.Starlark {
+		if err := classifier.CompileStarlark(s); err != nil {
+			report(SeverityError, "starlark["+s.Name+"]", "starlark script %s: %v", s.Name, err)
+		}
+	}
+
+	if p.Arbiter.Enabled {
+		var missing []string
+		for _, f := range []struct{ name, value string }{
+			{"provider", p.Arbiter.Provider},
+			{"model", p.Arbiter.Model},
+			{"api_key_env", p.Arbiter.APIKeyEnv},
+		} {
+			if f.value == "" {
+				missing = append(missing, "arbiter."+f.name)
+			}
+		}
+		switch len(missing) {
+		case 0:
+		case 1:
+			report(SeverityError, "arbiter.enabled", "arbiter is enabled but %s is empty", missing[0])
+		default:
+			report(SeverityError, "arbiter.enabled", "arbiter is enabled but %s are empty", strings.Join(missing, ", "))
+		}
+	}
+	return findings
+}
+
+// unmatchable explains why a protected_paths entry cannot match any path, or
+// returns "".
+func unmatchable(pp policy.ProtectedPath, negatable bool) string {
+	pattern := pp.Pattern()
+	switch {
+	case pattern == "":
+		return "empty pattern"
+	case pp.Negated() && !negatable:
+		return "a negation only unprotects paths matched by earlier entries"
+	case strings.HasPrefix(pattern, "$"):
+		name := strings.Trim(strings.SplitN(pattern[1:], "/", 2)[0], "{}")
+		if os.Getenv(name) == "" {
+			return "$" + name + " is not set"
+		}
+	case pattern == ".." || strings.HasPrefix(pattern, "../") || strings.HasPrefix(pattern, "./"):
+		return "relative patterns are matched against clean paths inside the repo"
+	}
+	if !doublestar.ValidatePattern(pattern) {
+		return "invalid glob"
+	}
+	return ""
+}
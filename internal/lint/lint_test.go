@@ -0,0 +1,67 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clash/internal/policy"
+)
+
+func TestLintFindsConflicts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clash.yaml")
+	data := "allow_commands: !append [dd, git, mkfs.ext4]\nprotected_paths: !append [\"../shared\", \"src/[\"]\narbiter:\n  enabled: true\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	findings := Layers([]policy.Layer{{Name: policy.LayerRepo, Path: path}})
+	if !HasErrors(findings) {
+		t.Fatalf("expected errors, got %v", findings)
+	}
+	for _, want := range []string{
+		`"dd" is in both allow_commands and block_commands`,
+		`"mkfs.ext4" never applies: block_commands entry "mkfs"`,
+		`"git" shadows the "git reset" safety rules`,
+		`"../shared" can never match`,
+		`"src/[" can never match: invalid glob`,
+		"arbiter is enabled but arbiter.provider, arbiter.model, arbiter.api_key_env are empty",
+	} {
+		found := false
+		for _, f := range findings {
+			if strings.Contains(f.Message, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing finding %q in %v", want, findings)
+		}
+	}
+
+	data = "arg_specs:\n  - command: rm\n    operands: [\"arg...\"]\n  - command: git  reset\n  - command: tar\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	findings = Layers([]policy.Layer{{Name: policy.LayerRepo, Path: path}})
+	if len(findings) != 2 || !strings.Contains(findings[0].Message, `"rm" redefines`) || !strings.Contains(findings[1].Message, `"git  reset" redefines`) {
+		t.Fatalf("expected the rm and git reset specs to be rejected, got %v", findings)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "detect.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "clash.yaml")
+	data = "detectors:\n  - name: local\n    command: [./detect.sh]\n  - name: missing\n    command: [./missing.sh]\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	findings = Layers([]policy.Layer{{Name: policy.LayerRepo, Path: path}})
+	if len(findings) != 1 || !strings.Contains(findings[0].Message, "detector missing: "+filepath.Join(dir, "missing.sh")+" not found") {
+		t.Fatalf("expected only the missing detector to be reported, got %v", findings)
+	}
+
+	if findings := Layers(nil); len(findings) != 0 {
+		t.Fatalf("default policy should lint clean, got %v", findings)
+	}
+}
@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDetectorTimeout applies when a detector sets no timeout.
+const DefaultDetectorTimeout = 2 * time.Second
+
+// Detector is an external signal detector: an executable that reads a JSON
+// description of each command on stdin and answers with signals, reasons, a
+// proposed decision and an optional preview hint on stdout.
+//
+//	detectors:
+//	  - name: deploy
+//	    command: [/opt/acme/bin/clash-deploy-detector, --strict]
+//	    match: [acme-deploy, kubectl]
+//	    timeout: 500ms
+type Detector struct {
+	Name string `yaml:"name"`
+	// Command is the detector's argv; a bare name is looked up on PATH and a
+	// relative path is relative to the directory of the policy file.
+	Command StringList `yaml:"command"`
+	// Match limits the detector to these command names; empty means all.
+	Match   StringList `yaml:"match,omitempty"`
+	Timeout string     `yaml:"timeout,omitempty"`
+	// Dir is the directory of the policy file that added the detector.
+	Dir string `yaml:"-"`
+}
+
+// Executable returns the detector's program resolved against its policy
+// file.
+func (d Detector) Executable() string {
+	name := d.Command[0]
+	if filepath.IsAbs(name) || !strings.ContainsRune(name, filepath.Separator) || d.Dir == "" {
+		return name
+	}
+	return filepath.Join(d.Dir, name)
+}
+
+// TimeoutDuration returns the detector's timeout.
+func (d Detector) TimeoutDuration() time.Duration {
+	if t, err := time.ParseDuration(d.Timeout); err == nil && t > 0 {
+		return t
+	}
+	return DefaultDetectorTimeout
+}
+
+// UnmarshalYAML validates the detector as it is decoded.
+func (d *Detector) UnmarshalYAML(node *yaml.Node) error {
+	type plain Detector
+	if err := node.Decode((*plain)(d)); err != nil {
+		return err
+	}
+	if d.Name == "" {
+		return fmt.Errorf("line %d: detector without a name", node.Line)
+	}
+	if len(d.Command) == 0 || d.Command[0] == "" {
+		return fmt.Errorf("line %d: detector %s: command is empty", node.Line, d.Name)
+	}
+	if d.Timeout != "" {
+		if t, err := time.ParseDuration(d.Timeout); err != nil || t <= 0 {
+			return fmt.Errorf("line %d: detector %s: invalid timeout %q", node.Line, d.Name, d.Timeout)
+		}
+	}
+	return nil
+}
+
+// checkNames rejects list entries that share a name.
+func checkNames[T any](what string, list []T, name func(T) string) error {
+	seen := map[string]bool{}
+	for _, item := range list {
+		if seen[name(item)] {
+			return fmt.Errorf("duplicate %s name %q", what, name(item))
+		}
+		seen[name(item)] = true
+	}
+	return nil
+}
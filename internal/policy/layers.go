@@ -0,0 +1,434 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SystemPath is the admin policy layer.
+var SystemPath = "/etc/clash/policy.yaml"
+
+// Layer names, lowest precedence first.
+const (
+	LayerSystem    = "system"
+	LayerUser      = "user"
+	LayerRepo      = "repo"
+	LayerDirectory = "directory"
+	LayerExplicit  = "explicit"
+	LayerDefault   = "default"
+)
+
+// Layer is one policy file in the resolution order.
+type Layer struct {
+	Name string
+	Path string
+}
+
+// Resolution is an effective policy together with the layers that produced
+// it.
+type Resolution struct {
+	Policy Policy
+	// Layers lists the files that were found and merged, in order.
+	Layers []Layer
+	// Warnings reports locked keys that a later layer tried to loosen.
+	Warnings []string
+	// Sources maps each effective value to the layer that set it. Keys are
+	// dotted paths ("thresholds.delete_count") and list entries
+	// ("block_commands[dd]").
+	Sources map[string]Layer
+}
+
+func (l Layer) String() string {
+	if l.Path == "" {
+		return l.Name
+	}
+	return l.Name + " " + l.Path
+}
+
+// lockedKeys are the keys a layer can list under locked. Sections lock each
+// of their fields.
+var lockedKeys = map[string][]string{
+	"thresholds":       {"thresholds.delete_count", "thresholds.modify_count", "thresholds.preview_sample", "thresholds.exceeded_action"},
+	"protected_paths":  nil,
+	"allow_commands":   nil,
+	"block_commands":   nil,
+	"confirm_commands": nil,
+	"network_egress":   nil,
+	"package_managers": nil,
+	"arg_specs":        nil,
+	"rules":            nil,
+	"detectors":        nil,
+	"starlark":         nil,
+	"arbiter":          nil,
+	"options":          {"options.allow_outside_repo", "options.require_clean_tree_for_break_glass"},
+	"break_glass":      {"break_glass.min_reason_length", "break_glass.cooldown", "break_glass.daily_quota"},
+}
+
+// Layers lists the policy files consulted for cwd, lowest precedence first:
+// the admin file, the user file, clash.yaml at the repo root and clash.yaml
+// in each directory from the repo root down to cwd. A non-empty explicit path
+// (--policy) replaces the repo and directory layers.
+func Layers(repoRoot, cwd, explicit string) []Layer {
+	layers := []Layer{{Name: LayerSystem, Path: SystemPath}}
+	if dir := userConfigDir(); dir != "" {
+		layers = append(layers, Layer{Name: LayerUser, Path: filepath.Join(dir, "clash", "policy.yaml")})
+	}
+	if explicit != "" {
+		return append(layers, Layer{Name: LayerExplicit, Path: explicit})
+	}
+	layers = append(layers, Layer{Name: LayerRepo, Path: filepath.Join(repoRoot, "clash.yaml")})
+	rel, err := filepath.Rel(repoRoot, cwd)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return layers
+	}
+	dir := repoRoot
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		layers = append(layers, Layer{Name: LayerDirectory, Path: filepath.Join(dir, "clash.yaml")})
+	}
+	return layers
+}
+
+func userConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config")
+}
+
+// Resolve merges the embedded default with every layer that exists for cwd.
+func Resolve(repoRoot, cwd, explicit string) (Resolution, error) {
+	return LoadLayers(Layers(repoRoot, cwd, explicit))
+}
+
+// LoadLayers merges the embedded default with each existing layer in order.
+// Keys listed under locked by a layer can only be tightened by the layers
+// after it.
+func LoadLayers(layers []Layer) (Resolution, error) {
+	base, err := parse(defaultPolicyData)
+	if err != nil {
+		return Resolution{}, fmt.Errorf("parse default policy: %w", err)
+	}
+	res := Resolution{Policy: base, Sources: map[string]Layer{}}
+	values := flatten(base)
+	for path := range values {
+		res.Sources[path] = Layer{Name: LayerDefault}
+	}
+	for _, l := range layers {
+		data, err := os.ReadFile(l.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return res, fmt.Errorf("read %s policy: %w", l.Name, err)
+		}
+		override, edits, err := parseLayer(data)
+		if err != nil {
+			return res, fmt.Errorf("parse %s policy %s: %w", l.Name, l.Path, err)
+		}
+		prev := res.Policy
+		merge(&res.Policy, override)
+		for key, edit := range edits {
+			if err := listKeys[key](&res.Policy, edit); err != nil {
+				return res, fmt.Errorf("%s policy %s: %s: %w", l.Name, l.Path, key, err)
+			}
+		}
+		for i := range res.Policy.Starlark {
+			if res.Policy.Starlark[i].Dir == "" {
+				res.Policy.Starlark[i].Dir = filepath.Dir(l.Path)
+			}
+		}
+		for i := range res.Policy.Detectors {
+			if res.Policy.Detectors[i].Dir == "" {
+				res.Policy.Detectors[i].Dir = filepath.Dir(l.Path)
+			}
+		}
+		for _, key := range prev.Locked {
+			fields := lockedKeys[key]
+			if fields == nil {
+				fields = []string{key}
+			}
+			for _, field := range fields {
+				if tighten(field, &res.Policy, prev) {
+					res.Warnings = append(res.Warnings, fmt.Sprintf("%s (%s): %s is locked and can only be tightened", l.Name, l.Path, field))
+				}
+			}
+		}
+		var locked []Rule
+		for _, r := range prev.Rules {
+			if r.Locked {
+				locked = append(locked, r)
+			}
+		}
+		if len(locked) > 0 && keepRules(&res.Policy, locked) {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%s (%s): locked rules cannot be removed or changed", l.Name, l.Path))
+		}
+		res.Layers = append(res.Layers, l)
+
+		next := flatten(res.Policy)
+		for path, v := range next {
+			if old, ok := values[path]; !ok || old != v {
+				res.Sources[path] = l
+			}
+		}
+		for path := range res.Sources {
+			if _, ok := next[path]; !ok {
+				delete(res.Sources, path)
+			}
+		}
+		values = next
+	}
+	return res, nil
+}
+
+func isLockedField(key string) bool {
+	for _, fields := range lockedKeys {
+		for _, f := range fields {
+			if f == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tighten undoes any loosening of a locked key by the layer merged into prev,
+// and reports whether it had to. Lists that restrict commands are
+// unioned, allow_commands can only shrink, limits keep the stricter value,
+// locked rules stay and keys without an order (arg_specs, arbiter,
+// preview_sample) keep prev.
+func tighten(key string, p *Policy, prev Policy) bool {
+	switch key {
+	case "thresholds.delete_count":
+		return tightenLimit(&p.Thresholds.DeleteCount, prev.Thresholds.DeleteCount)
+	case "thresholds.modify_count":
+		return tightenLimit(&p.Thresholds.ModifyCount, prev.Thresholds.ModifyCount)
+	case "thresholds.preview_sample":
+		return keep(&p.Thresholds.PreviewSample, prev.Thresholds.PreviewSample)
+	case "thresholds.exceeded_action":
+		if prev.Thresholds.ExceededAction == ThresholdBlock {
+			return keep(&p.Thresholds.ExceededAction, ThresholdBlock)
+		}
+	case "protected_paths":
+		// Later entries win, so the locked entries go last: the new ones
+		// can only decide paths the locked ones leave alone.
+		var added []ProtectedPath
+		kept := 0
+		for _, pp := range p.ProtectedPaths {
+			if containsProtected(prev.ProtectedPaths, pp) {
+				kept++
+			} else {
+				added = append(added, pp)
+			}
+		}
+		p.ProtectedPaths = append(added, prev.ProtectedPaths...)
+		return kept < len(prev.ProtectedPaths)
+	case "allow_commands":
+		var kept []string
+		for _, c := range p.AllowCommands {
+			if contains(prev.AllowCommands, c) {
+				kept = append(kept, c)
+			}
+		}
+		loosened := len(kept) < len(p.AllowCommands)
+		p.AllowCommands = kept
+		return loosened
+	case "block_commands":
+		return union(&p.BlockCommands, prev.BlockCommands)
+	case "confirm_commands":
+		return union(&p.ConfirmCommands, prev.ConfirmCommands)
+	case "network_egress":
+		return union(&p.NetworkEgress, prev.NetworkEgress)
+	case "package_managers":
+		return union(&p.PackageManagers, prev.PackageManagers)
+	case "arg_specs":
+		if !reflect.DeepEqual(p.ArgSpecs, prev.ArgSpecs) {
+			p.ArgSpecs = prev.ArgSpecs
+			return true
+		}
+	case "rules":
+		return keepRules(p, prev.Rules)
+	case "detectors":
+		return keepNamed(&p.Detectors, prev.Detectors, func(d Detector) string { return d.Name })
+	case "starlark":
+		return keepNamed(&p.Starlark, prev.Starlark, func(s StarlarkScript) string { return s.Name })
+	case "arbiter":
+		return keep(&p.Arbiter, prev.Arbiter)
+	case "options.allow_outside_repo":
+		return keep(&p.Options.AllowOutsideRepo, prev.Options.AllowOutsideRepo)
+	case "options.require_clean_tree_for_break_glass":
+		if prev.Options.RequireCleanTreeForBreakGlass {
+			return keep(&p.Options.RequireCleanTreeForBreakGlass, true)
+		}
+	case "break_glass.min_reason_length":
+		if p.BreakGlass.MinReasonLength < prev.BreakGlass.MinReasonLength {
+			p.BreakGlass.MinReasonLength = prev.BreakGlass.MinReasonLength
+			return true
+		}
+	case "break_glass.cooldown":
+		was, _ := time.ParseDuration(prev.BreakGlass.Cooldown)
+		now, err := time.ParseDuration(p.BreakGlass.Cooldown)
+		if err != nil || now < was {
+			return keep(&p.BreakGlass.Cooldown, prev.BreakGlass.Cooldown)
+		}
+	case "break_glass.daily_quota":
+		quota := p.BreakGlass.Quota()
+		if tightenLimit(&quota, prev.BreakGlass.Quota()) {
+			p.BreakGlass.DailyQuota = &quota
+			return true
+		}
+	}
+	return false
+}
+
+// tightenLimit keeps the smaller of two limits where 0 means unlimited.
+func tightenLimit(v *int, prev int) bool {
+	if prev > 0 && (*v == 0 || *v > prev) {
+		*v = prev
+		return true
+	}
+	return false
+}
+
+func keep[T comparable](v *T, prev T) bool {
+	if *v == prev {
+		return false
+	}
+	*v = prev
+	return true
+}
+
+// keepNamed puts the entries of prev back, unchanged, into *list and reports
+// whether the layer just merged had removed or changed any of them.
+func keepNamed[T any](list *[]T, prev []T, key func(T) string) bool {
+	out := append([]T{}, *list...)
+	changed := false
+	for _, item := range prev {
+		i := 0
+		for i < len(out) && key(out[i]) != key(item) {
+			i++
+		}
+		if i == len(out) {
+			out = append(out, item)
+			changed = true
+			continue
+		}
+		if !reflect.DeepEqual(out[i], item) {
+			out[i] = item
+			changed = true
+		}
+	}
+	*list = out
+	return changed
+}
+
+// union adds the entries of prev missing from *list and reports whether
+// there were any.
+func union(list *[]string, prev []string) bool {
+	out := append([]string{}, *list...)
+	for _, c := range prev {
+		if !contains(out, c) {
+			out = append(out, c)
+		}
+	}
+	added := len(out) > len(*list)
+	*list = out
+	return added
+}
+
+func containsProtected(list []ProtectedPath, pp ProtectedPath) bool {
+	for _, c := range list {
+		if c == pp {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, c := range list {
+		if c == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flatten renders a policy as source paths and their values.
+func flatten(p Policy) map[string]string {
+	var doc yaml.Node
+	out := map[string]string{}
+	if err := doc.Encode(p); err != nil {
+		return out
+	}
+	walkValues(&doc, "", func(path string, n *yaml.Node) {
+		data, _ := yaml.Marshal(n)
+		out[path] = string(data)
+	})
+	return out
+}
+
+// walkValues calls fn for each scalar and list entry below n.
+func walkValues(n *yaml.Node, path string, fn func(path string, n *yaml.Node)) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			walkValues(n.Content[i+1], key, fn)
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			fn(path+"["+entryKey(item)+"]", item)
+		}
+	default:
+		fn(path, n)
+	}
+}
+
+// entryKey identifies a list entry the way merge directives do.
+func entryKey(n *yaml.Node) string {
+	if n.Kind != yaml.MappingNode {
+		return n.Value
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if k := n.Content[i].Value; k == "path" || k == "command" || k == "id" || k == "name" {
+			return n.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// SourcesYAML renders the effective policy with each value annotated with
+// the layer that set it.
+func (r Resolution) SourcesYAML() (string, error) {
+	var doc yaml.Node
+	if err := doc.Encode(r.Policy); err != nil {
+		return "", err
+	}
+	walkValues(&doc, "", func(path string, n *yaml.Node) {
+		if l, ok := r.Sources[path]; ok {
+			if n.Kind == yaml.MappingNode && len(n.Content) > 1 {
+				n = n.Content[1]
+			}
+			n.LineComment = l.String()
+		}
+	})
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
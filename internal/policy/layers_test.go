@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveAppliesLocks(t *testing.T) {
+	tmp := t.TempDir()
+	repo := filepath.Join(tmp, "repo")
+	sub := filepath.Join(repo, "services", "api")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(tmp, "system.yaml"): "locked: [thresholds, allow_commands, block_commands, protected_paths]\nthresholds:\n  delete_count: 10\nblock_commands: [mkfs, dd, terraform]\n",
+		filepath.Join(repo, "clash.yaml"): "thresholds:\n  delete_count: 1000\nallow_commands: [ls, rm]\nblock_commands: [mkfs]\nprotected_paths:\n  - \"!/etc\"\n",
+		filepath.Join(sub, "clash.yaml"):  "block_commands: [helm]\n",
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := SystemPath
+	SystemPath = filepath.Join(tmp, "system.yaml")
+	defer func() { SystemPath = saved }()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+
+	res, err := Resolve(repo, sub, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, l := range res.Layers {
+		names = append(names, l.Name)
+	}
+	if strings.Join(names, ",") != "system,repo,directory" {
+		t.Fatalf("unexpected layers %v", names)
+	}
+	if res.Policy.Thresholds.DeleteCount != 10 || len(res.Warnings) == 0 {
+		t.Fatalf("locked threshold loosened: %d (%v)", res.Policy.Thresholds.DeleteCount, res.Warnings)
+	}
+	blocked := strings.Join(res.Policy.BlockCommands, ",")
+	for _, cmd := range []string{"dd", "terraform", "helm"} {
+		if !contains(res.Policy.BlockCommands, cmd) {
+			t.Errorf("expected %s in block_commands, got %s", cmd, blocked)
+		}
+	}
+	if contains(res.Policy.AllowCommands, "rm") {
+		t.Errorf("locked allow_commands gained rm: %v", res.Policy.AllowCommands)
+	}
+	// Locked entries keep the last word, so /etc must follow the negation.
+	last := map[string]int{}
+	for i, pp := range res.Policy.ProtectedPaths {
+		last[pp.Path] = i
+	}
+	if last["/etc"] < last["!/etc"] {
+		t.Errorf("locked /etc overridden by a negation: %v", res.Policy.ProtectedPaths)
+	}
+}
@@ -0,0 +1,209 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Merge directives for list-valued keys. A plain list replaces the list from
+// earlier layers; a mapping or a tagged list edits it instead:
+//
+//	network_egress:
+//	  append: [terraform]
+//	  remove: [scp]
+//	package_managers: !append [cargo]
+const (
+	DirectiveAppend  = "append"
+	DirectiveRemove  = "remove"
+	DirectiveReplace = "replace"
+)
+
+// listEdit is one layer's directives for a list. They apply in the order
+// replace, remove, append.
+type listEdit struct {
+	replace *yaml.Node
+	remove  *yaml.Node
+	append  *yaml.Node
+}
+
+// listKeys are the keys that accept merge directives, with how to apply them.
+// Entries are identified by their value, protected path pattern, arg spec
+// command or rule id; remove takes those identities.
+var listKeys = map[string]func(p *Policy, e listEdit) error{
+	"protected_paths": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.ProtectedPaths, e, func(pp ProtectedPath) string { return pp.Path })
+	},
+	"allow_commands": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.AllowCommands, e, identity)
+	},
+	"block_commands": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.BlockCommands, e, identity)
+	},
+	"confirm_commands": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.ConfirmCommands, e, identity)
+	},
+	"network_egress": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.NetworkEgress, e, identity)
+	},
+	"package_managers": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.PackageManagers, e, identity)
+	},
+	"arg_specs": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.ArgSpecs, e, func(s ArgSpec) string { return s.Command })
+	},
+	"rules": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.Rules, e, func(r Rule) string { return r.ID })
+	},
+	"detectors": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.Detectors, e, func(d Detector) string { return d.Name })
+	},
+	"starlark": func(p *Policy, e listEdit) error {
+		return applyEdit(&p.Starlark, e, func(s StarlarkScript) string { return s.Name })
+	},
+}
+
+func identity(s string) string { return s }
+
+// parseLayer decodes a policy file strictly, taking merge directives out of
+// the document before the rest is decoded into a Policy.
+func parseLayer(data []byte) (Policy, map[string]listEdit, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Policy{}, nil, err
+	}
+	if len(doc.Content) == 0 {
+		return Policy{}, nil, nil
+	}
+	edits := map[string]listEdit{}
+	fields := yamlFields(reflect.TypeOf(Policy{}))
+	if root := doc.Content[0]; root.Kind == yaml.MappingNode {
+		kept := root.Content[:0:0]
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			key, value := root.Content[i], root.Content[i+1]
+			if _, ok := listKeys[key.Value]; ok {
+				edit, ok, err := parseListEdit(key.Value, value)
+				if err != nil {
+					return Policy{}, nil, err
+				}
+				if ok {
+					for _, n := range []*yaml.Node{edit.replace, edit.append} {
+						if n == nil {
+							continue
+						}
+						if err := checkFields(n, fields[key.Value]); err != nil {
+							return Policy{}, nil, err
+						}
+					}
+					edits[key.Value] = edit
+					continue
+				}
+			}
+			kept = append(kept, key, value)
+		}
+		root.Content = kept
+		if err := checkFields(root, reflect.TypeOf(Policy{})); err != nil {
+			return Policy{}, nil, err
+		}
+	}
+	var p Policy
+	if err := doc.Decode(&p); err != nil {
+		return Policy{}, nil, err
+	}
+	if err := checkRuleIDs(p.Rules); err != nil {
+		return Policy{}, nil, err
+	}
+	if err := checkNames("detector", p.Detectors, func(d Detector) string { return d.Name }); err != nil {
+		return Policy{}, nil, err
+	}
+	if err := checkNames("starlark script", p.Starlark, func(s StarlarkScript) string { return s.Name }); err != nil {
+		return Policy{}, nil, err
+	}
+	for _, key := range p.Locked {
+		if _, ok := lockedKeys[key]; !ok && !isLockedField(key) {
+			return Policy{}, nil, fmt.Errorf("unknown locked key %q", key)
+		}
+	}
+	return p, edits, nil
+}
+
+// parseListEdit reads the mapping or tagged-list form. ok is false for a
+// plain list.
+func parseListEdit(key string, n *yaml.Node) (listEdit, bool, error) {
+	var edit listEdit
+	switch n.Kind {
+	case yaml.SequenceNode:
+		directive := n.Tag
+		if directive == "" || directive == "!!seq" {
+			return edit, false, nil
+		}
+		list := *n
+		list.Tag = ""
+		if err := edit.set(directive[1:], &list); err != nil {
+			return edit, false, fmt.Errorf("line %d: %s: %w", n.Line, key, err)
+		}
+		return edit, true, nil
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := edit.set(n.Content[i].Value, n.Content[i+1]); err != nil {
+				return edit, false, fmt.Errorf("line %d: %s: %w", n.Content[i].Line, key, err)
+			}
+		}
+		return edit, true, nil
+	}
+	return edit, false, nil
+}
+
+func (e *listEdit) set(directive string, n *yaml.Node) error {
+	switch directive {
+	case DirectiveAppend:
+		e.append = n
+	case DirectiveRemove:
+		e.remove = n
+	case DirectiveReplace:
+		e.replace = n
+	default:
+		return fmt.Errorf("unknown merge directive %q (want append, remove or replace)", directive)
+	}
+	return nil
+}
+
+// applyEdit applies a layer's directives to list. Appending an entry that is
+// already present replaces it and moves it to the end.
+func applyEdit[T any](list *[]T, e listEdit, key func(T) string) error {
+	if e.replace != nil {
+		var items []T
+		if err := e.replace.Decode(&items); err != nil {
+			return err
+		}
+		*list = items
+	}
+	drop := map[string]bool{}
+	if e.remove != nil {
+		var keys []string
+		if err := e.remove.Decode(&keys); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			drop[k] = true
+		}
+	}
+	var added []T
+	if e.append != nil {
+		if err := e.append.Decode(&added); err != nil {
+			return err
+		}
+		for _, item := range added {
+			drop[key(item)] = true
+		}
+	}
+	out := []T{}
+	for _, item := range *list {
+		if !drop[key(item)] {
+			out = append(out, item)
+		}
+	}
+	*list = append(out, added...)
+	return nil
+}
@@ -0,0 +1,50 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListMergeDirectives(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, data string) Layer {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return Layer{Name: strings.TrimSuffix(name, ".yaml"), Path: path}
+	}
+	admin := write("system.yaml", "locked: [block_commands]\nblock_commands: !append [terraform]\n")
+	repo := write("repo.yaml", "network_egress:\n  append: [terraform]\n  remove: [scp]\npackage_managers: !replace [cargo]\nblock_commands: !remove [dd, terraform]\n")
+	res, err := LoadLayers([]Layer{admin, repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol := res.Policy
+	if !contains(pol.NetworkEgress, "terraform") || contains(pol.NetworkEgress, "scp") || !contains(pol.NetworkEgress, "curl") {
+		t.Fatalf("network_egress not edited: %v", pol.NetworkEgress)
+	}
+	if strings.Join(pol.PackageManagers, ",") != "cargo" {
+		t.Fatalf("package_managers not replaced: %v", pol.PackageManagers)
+	}
+	if !contains(pol.BlockCommands, "dd") || !contains(pol.BlockCommands, "terraform") || len(res.Warnings) != 1 {
+		t.Fatalf("locked block_commands loosened: %v (%v)", pol.BlockCommands, res.Warnings)
+	}
+	if got := res.Sources["network_egress[terraform]"]; got != repo {
+		t.Fatalf("expected terraform egress from repo layer, got %v", got)
+	}
+	if got := res.Sources["block_commands[mkfs]"]; got.Name != LayerDefault {
+		t.Fatalf("expected mkfs from default, got %v", got)
+	}
+	out, err := res.SourcesYAML()
+	if err != nil || !strings.Contains(out, "- cargo # "+repo.String()) {
+		t.Fatalf("sources not annotated: %v\n%s", err, out)
+	}
+
+	bad := write("bad.yaml", "allow_commands:\n  prepend: [ls]\n")
+	if _, err := LoadLayers([]Layer{bad}); err == nil || !strings.Contains(err.Error(), "unknown merge directive") {
+		t.Fatalf("expected directive error, got %v", err)
+	}
+}
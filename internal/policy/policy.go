@@ -3,8 +3,8 @@ package policy
 import (
 	"embed"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,11 +12,20 @@ import (
 //go:embed ../../configs/default_policy.yaml
 var defaultPolicyData []byte
 
+// Threshold actions taken when a preview exceeds delete_count or
+// modify_count.
+const (
+	ThresholdTyped = "typed"
+	ThresholdBlock = "block"
+)
+
 // Thresholds controls preview and risk limits.
 type Thresholds struct {
 	DeleteCount  int `yaml:"delete_count"`
 	ModifyCount  int `yaml:"modify_count"`
 	PreviewSample int `yaml:"preview_sample"`
+	// ExceededAction is "typed" (retype a confirmation phrase) or "block".
+	ExceededAction string `yaml:"exceeded_action"`
 }
 
 // ArbiterConfig describes optional LLM arbiter settings.
@@ -33,55 +42,162 @@ type Options struct {
 	RequireCleanTreeForBreakGlass bool `yaml:"require_clean_tree_for_break_glass"`
 }
 
+// BreakGlassConfig limits the break-glass override.
+type BreakGlassConfig struct {
+	// MinReasonLength is the minimum length of --break-glass-reason.
+	MinReasonLength int `yaml:"min_reason_length"`
+	// Cooldown is the minimum time between overrides, e.g. "10m".
+	Cooldown string `yaml:"cooldown"`
+	// DailyQuota caps overrides per calendar day; 0 means unlimited. It is a
+	// pointer so that a layer can lift the default quota with 0.
+	DailyQuota *int `yaml:"daily_quota"`
+}
+
+// Quota returns the daily quota, 0 when unset or unlimited.
+func (b BreakGlassConfig) Quota() int {
+	if b.DailyQuota == nil {
+		return 0
+	}
+	return *b.DailyQuota
+}
+
+// ArgSpec describes how a command's arguments are spelled and what its
+// operands mean. Operand patterns are written "role[:access][...]" where role
+// is source, destination, path or arg (not a path), access is read or write
+// and a trailing "..." makes the entry variadic, e.g. ["source:write...",
+// "destination:write"] for mv. Flags lists options that take no value so
+// that abbreviated long options (git reset --ha) expand to them. Words that
+// fully match the DashOperands regular expression are operands even though
+// they start with a dash (chmod -x file).
+type ArgSpec struct {
+	Command         string            `yaml:"command"`
+	Flags           []string          `yaml:"flags,omitempty"`
+	ValueFlags      []string          `yaml:"value_flags,omitempty"`
+	Aliases         map[string]string `yaml:"aliases,omitempty"`
+	Operands        []string          `yaml:"operands,omitempty"`
+	AfterDoubleDash string            `yaml:"after_double_dash,omitempty"`
+	FlagOperands    map[string]string `yaml:"flag_operands,omitempty"`
+	StopAtOption    bool              `yaml:"stop_at_option,omitempty"`
+	DashOperands    string            `yaml:"dash_operands,omitempty"`
+}
+
+// UnmarshalYAML checks that dash_operands compiles.
+func (s *ArgSpec) UnmarshalYAML(node *yaml.Node) error {
+	type plain ArgSpec
+	if err := node.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(s.DashOperands); err != nil {
+		return fmt.Errorf("line %d: arg spec %s: dash_operands: %w", node.Line, s.Command, err)
+	}
+	return nil
+}
+
+// Protected path actions.
+const (
+	ProtectedConfirm = "confirm"
+	ProtectedBlock   = "block"
+)
+
+// ProtectedPath is a protected_paths entry, written either as a plain pattern
+// or as a mapping:
+//
+//	protected_paths:
+//	  - /etc
+//	  - "**/.env*"
+//	  - "!.env.example"
+//	  - path: "**/*.pem"
+//	    action: block
+//
+// Absolute patterns (after ~ and $VAR expansion) match resolved paths;
+// relative ones match paths relative to the repo root. Patterns use
+// doublestar globs and also protect everything beneath a match unless exact
+// is set. A leading "!" unprotects paths matched by earlier entries.
+type ProtectedPath struct {
+	Path   string `yaml:"path"`
+	Action string `yaml:"action,omitempty"`
+	Exact  bool   `yaml:"exact,omitempty"`
+}
+
+// UnmarshalYAML accepts the scalar and mapping forms.
+func (pp *ProtectedPath) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		pp.Path = node.Value
+		return nil
+	}
+	type plain ProtectedPath
+	if err := node.Decode((*plain)(pp)); err != nil {
+		return err
+	}
+	switch pp.Action {
+	case "", ProtectedConfirm, ProtectedBlock:
+		return nil
+	}
+	return fmt.Errorf("line %d: protected path %q: unknown action %q", node.Line, pp.Path, pp.Action)
+}
+
+// MarshalYAML writes entries without options in the scalar form.
+func (pp ProtectedPath) MarshalYAML() (interface{}, error) {
+	if pp.Action == "" && !pp.Exact {
+		return pp.Path, nil
+	}
+	type plain ProtectedPath
+	return plain(pp), nil
+}
+
+// Negated reports a "!pattern" entry.
+func (pp ProtectedPath) Negated() bool {
+	return strings.HasPrefix(pp.Path, "!")
+}
+
+// Pattern returns the path pattern without a leading "!".
+func (pp ProtectedPath) Pattern() string {
+	return strings.TrimPrefix(pp.Path, "!")
+}
+
 // Policy represents the effective ruleset.
 type Policy struct {
 	Thresholds      Thresholds   `yaml:"thresholds"`
-	ProtectedPaths  []string     `yaml:"protected_paths"`
+	ProtectedPaths  []ProtectedPath `yaml:"protected_paths"`
 	AllowCommands   []string     `yaml:"allow_commands"`
 	BlockCommands   []string     `yaml:"block_commands"`
 	ConfirmCommands []string     `yaml:"confirm_commands"`
 	NetworkEgress   []string     `yaml:"network_egress"`
 	PackageManagers []string     `yaml:"package_managers"`
+	ArgSpecs        []ArgSpec    `yaml:"arg_specs,omitempty"`
 	Arbiter         ArbiterConfig `yaml:"arbiter"`
 	Options         Options      `yaml:"options"`
+	BreakGlass      BreakGlassConfig `yaml:"break_glass"`
+	Rules           []Rule       `yaml:"rules,omitempty"`
+	Detectors       []Detector   `yaml:"detectors,omitempty"`
+	Starlark        []StarlarkScript `yaml:"starlark,omitempty"`
+	// Locked lists keys that later policy layers may only tighten, either
+	// whole sections ("thresholds") or single fields ("options.allow_outside_repo").
+	Locked          []string     `yaml:"locked,omitempty"`
 }
 
-// Load returns the effective policy, merging defaults with a repo-local file if present.
+// Load returns the effective policy, merging defaults with the file at path
+// if present. Use Resolve to apply the full layer stack.
 func Load(path string) (Policy, error) {
-	base, err := parse(defaultPolicyData)
-	if err != nil {
-		return Policy{}, fmt.Errorf("parse default policy: %w", err)
-	}
-
-	if path == "" {
-		return base, nil
-	}
-	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			return base, nil
-		}
-		return base, fmt.Errorf("stat policy: %w", err)
-	}
-
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return base, fmt.Errorf("read policy: %w", err)
-	}
-	user, err := parse(data)
-	if err != nil {
-		return base, fmt.Errorf("parse policy: %w", err)
+	var layers []Layer
+	if path != "" {
+		layers = append(layers, Layer{Name: LayerExplicit, Path: path})
 	}
+	res, err := LoadLayers(layers)
+	return res.Policy, err
+}
 
-	merge(&base, user)
-	return base, nil
+// Check validates one policy file: unknown keys, malformed values, merge
+// directives and locked keys.
+func Check(data []byte) error {
+	_, err := parse(data)
+	return err
 }
 
+// parse decodes a policy file, rejecting unknown keys.
 func parse(data []byte) (Policy, error) {
-	var p Policy
-	if err := yaml.Unmarshal(data, &p); err != nil {
-		return Policy{}, err
-	}
-	return p, nil
+	p, _, err := parseLayer(data)
+	return p, err
 }
 
 func merge(base *Policy, override Policy) {
@@ -94,6 +210,9 @@ func merge(base *Policy, override Policy) {
 	if override.Thresholds.PreviewSample != 0 {
 		base.Thresholds.PreviewSample = override.Thresholds.PreviewSample
 	}
+	if override.Thresholds.ExceededAction != "" {
+		base.Thresholds.ExceededAction = override.Thresholds.ExceededAction
+	}
 
 	if len(override.ProtectedPaths) > 0 {
 		base.ProtectedPaths = override.ProtectedPaths
@@ -113,6 +232,18 @@ func merge(base *Policy, override Policy) {
 	if len(override.PackageManagers) > 0 {
 		base.PackageManagers = override.PackageManagers
 	}
+	if len(override.ArgSpecs) > 0 {
+		base.ArgSpecs = override.ArgSpecs
+	}
+	if len(override.Rules) > 0 {
+		base.Rules = override.Rules
+	}
+	if len(override.Detectors) > 0 {
+		base.Detectors = override.Detectors
+	}
+	if len(override.Starlark) > 0 {
+		base.Starlark = override.Starlark
+	}
 
 	if override.Arbiter.Enabled {
 		base.Arbiter = override.Arbiter
@@ -122,6 +253,23 @@ func merge(base *Policy, override Policy) {
 		}
 	}
 
+	if override.BreakGlass.MinReasonLength != 0 {
+		base.BreakGlass.MinReasonLength = override.BreakGlass.MinReasonLength
+	}
+	if override.BreakGlass.Cooldown != "" {
+		base.BreakGlass.Cooldown = override.BreakGlass.Cooldown
+	}
+	if override.BreakGlass.DailyQuota != nil {
+		quota := *override.BreakGlass.DailyQuota
+		base.BreakGlass.DailyQuota = &quota
+	}
+
+	for _, key := range override.Locked {
+		if !contains(base.Locked, key) {
+			base.Locked = append(base.Locked, key)
+		}
+	}
+
 	base.Options.AllowOutsideRepo = base.Options.AllowOutsideRepo || override.Options.AllowOutsideRepo
 	if override.Options.RequireCleanTreeForBreakGlass {
 		base.Options.RequireCleanTreeForBreakGlass = true
@@ -0,0 +1,46 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDailyQuotaOverride(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, data string) Layer {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return Layer{Name: name, Path: path}
+	}
+
+	def, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.BreakGlass.Quota() != 5 {
+		t.Fatalf("expected the default quota of 5, got %d", def.BreakGlass.Quota())
+	}
+	unlimited := write("unlimited.yaml", "break_glass:\n  daily_quota: 0\n")
+	res, err := LoadLayers([]Layer{unlimited})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := res.Policy.BreakGlass.DailyQuota; q == nil || *q != 0 {
+		t.Fatalf("daily_quota: 0 should lift the quota, got %v", q)
+	}
+
+	locked := write("locked.yaml", "locked: [break_glass.daily_quota]\nbreak_glass:\n  daily_quota: 3\n")
+	res, err = LoadLayers([]Layer{locked, unlimited})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Policy.BreakGlass.Quota() != 3 || len(res.Warnings) != 1 {
+		t.Fatalf("locked quota lifted: %d (%v)", res.Policy.BreakGlass.Quota(), res.Warnings)
+	}
+	if def.BreakGlass.Quota() != 5 {
+		t.Fatalf("loading layers changed an earlier policy's quota to %d", def.BreakGlass.Quota())
+	}
+}
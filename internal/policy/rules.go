@@ -0,0 +1,148 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule actions.
+const (
+	RuleAllow     = "allow"
+	RuleConfirm   = "confirm"
+	RuleBlock     = "block"
+	RuleHardBlock = "hard-block"
+)
+
+// Rule is a rules entry:
+//
+//	rules:
+//	  - id: no-force-push-main
+//	    priority: 10
+//	    match:
+//	      command: git
+//	      subcommand: push
+//	      flags_present: [--force]
+//	      argv: '\b(main|master)\b'
+//	    action: block
+//	    reason: force-pushing the main branch
+//	    safer_alternative: git push --force-with-lease origin HEAD:feature
+//
+// Locked rules are evaluated before all others and later layers cannot
+// remove or change them.
+type Rule struct {
+	ID               string    `yaml:"id"`
+	Priority         int       `yaml:"priority,omitempty"`
+	Match            RuleMatch `yaml:"match"`
+	Action           string    `yaml:"action"`
+	Reason           string    `yaml:"reason,omitempty"`
+	SaferAlternative string    `yaml:"safer_alternative,omitempty"`
+	Locked           bool      `yaml:"locked,omitempty"`
+}
+
+// RuleMatch holds a rule's conditions; every condition that is set must
+// hold. Command, subcommand and flags compare normalized names, argv is a
+// regular expression searched in the space-joined argv, targets are
+// protected_paths-style globs matched against file operands (any of them
+// for block and confirm rules, all of them for allow rules), and env maps
+// variable names to regular expressions the whole value must match.
+type RuleMatch struct {
+	Command      StringList        `yaml:"command,omitempty"`
+	Subcommand   StringList        `yaml:"subcommand,omitempty"`
+	FlagsPresent StringList        `yaml:"flags_present,omitempty"`
+	FlagsAbsent  StringList        `yaml:"flags_absent,omitempty"`
+	Argv         string            `yaml:"argv,omitempty"`
+	Targets      StringList        `yaml:"targets,omitempty"`
+	InRepo       *bool             `yaml:"in_repo,omitempty"`
+	OutsideRepo  *bool             `yaml:"outside_repo,omitempty"`
+	GitDirty     *bool             `yaml:"git_dirty,omitempty"`
+	Env          map[string]string `yaml:"env,omitempty"`
+	// When is a CEL expression over cmd, ctx and preview that must be
+	// true, e.g. `size(cmd.targets) > 10`.
+	When string `yaml:"when,omitempty"`
+}
+
+// StringList is a list of strings that may be written as a single string.
+type StringList []string
+
+// UnmarshalYAML accepts a scalar or a sequence.
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = StringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// UnmarshalYAML validates the rule as it is decoded.
+func (r *Rule) UnmarshalYAML(node *yaml.Node) error {
+	type plain Rule
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	if r.ID == "" {
+		return fmt.Errorf("line %d: rule without an id", node.Line)
+	}
+	switch r.Action {
+	case RuleAllow, RuleConfirm, RuleBlock, RuleHardBlock:
+	default:
+		return fmt.Errorf("line %d: rule %s: unknown action %q (want allow, confirm, block or hard-block)", node.Line, r.ID, r.Action)
+	}
+	if reflect.DeepEqual(r.Match, RuleMatch{}) {
+		return fmt.Errorf("line %d: rule %s: match has no conditions", node.Line, r.ID)
+	}
+	if _, err := regexp.Compile(r.Match.Argv); err != nil {
+		return fmt.Errorf("line %d: rule %s: argv: %w", node.Line, r.ID, err)
+	}
+	for name, pattern := range r.Match.Env {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("line %d: rule %s: env %s: %w", node.Line, r.ID, name, err)
+		}
+	}
+	return nil
+}
+
+// checkRuleIDs rejects rules that share an id.
+func checkRuleIDs(rules []Rule) error {
+	seen := map[string]bool{}
+	for _, r := range rules {
+		if seen[r.ID] {
+			return fmt.Errorf("duplicate rule id %q", r.ID)
+		}
+		seen[r.ID] = true
+	}
+	return nil
+}
+
+// keepRules puts rules back, unchanged and locked, into p and reports
+// whether the layer just merged had removed or changed any of them.
+func keepRules(p *Policy, rules []Rule) bool {
+	p.Rules = append([]Rule{}, p.Rules...)
+	changed := false
+	for _, r := range rules {
+		r.Locked = true
+		i := 0
+		for i < len(p.Rules) && p.Rules[i].ID != r.ID {
+			i++
+		}
+		if i == len(p.Rules) {
+			p.Rules = append(p.Rules, r)
+			changed = true
+			continue
+		}
+		current := p.Rules[i]
+		current.Locked = true
+		if !reflect.DeepEqual(current, r) {
+			changed = true
+		}
+		p.Rules[i] = r
+	}
+	return changed
+}
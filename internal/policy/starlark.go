@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultStarlarkSteps bounds a Starlark script's work per call when it sets
+// no max_steps.
+const DefaultStarlarkSteps = 1000000
+
+// StarlarkScript is a .star file defining evaluate(cmd, ctx), run in an
+// embedded interpreter for every command whose name is in Match (every
+// command when Match is empty):
+//
+//	starlark:
+//	  - name: terraform
+//	    path: policy/terraform.star
+//	    match: terraform
+type StarlarkScript struct {
+	Name string `yaml:"name"`
+	// Path is relative to the directory of the policy file naming it.
+	Path     string     `yaml:"path"`
+	Match    StringList `yaml:"match,omitempty"`
+	MaxSteps uint64     `yaml:"max_steps,omitempty"`
+	// Dir is the directory of the policy file that added the script.
+	Dir string `yaml:"-"`
+}
+
+// File returns the script's path resolved against its policy file.
+func (s StarlarkScript) File() string {
+	if filepath.IsAbs(s.Path) || s.Dir == "" {
+		return s.Path
+	}
+	return filepath.Join(s.Dir, s.Path)
+}
+
+// Steps returns the script's execution step limit.
+func (s StarlarkScript) Steps() uint64 {
+	if s.MaxSteps > 0 {
+		return s.MaxSteps
+	}
+	return DefaultStarlarkSteps
+}
+
+// UnmarshalYAML validates the script entry as it is decoded.
+func (s *StarlarkScript) UnmarshalYAML(node *yaml.Node) error {
+	type plain StarlarkScript
+	if err := node.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	if s.Name == "" {
+		return fmt.Errorf("line %d: starlark script without a name", node.Line)
+	}
+	if !strings.HasSuffix(s.Path, ".star") {
+		return fmt.Errorf("line %d: starlark script %s: path %q is not a .star file", node.Line, s.Name, s.Path)
+	}
+	return nil
+}
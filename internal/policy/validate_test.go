@@ -0,0 +1,28 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRejectsUnknownKeys(t *testing.T) {
+	tmp := t.TempDir()
+	cases := map[string]string{
+		"protect_paths: [/srv]\n":                                           "unknown key \"protect_paths\" (did you mean \"protected_paths\"?)",
+		"allow_comands: [ls]\n":                                             "did you mean \"allow_commands\"",
+		"protected_paths:\n  - path: /srv\n    actoin: block\n":             "line 3: unknown key \"actoin\"",
+		"arg_specs:\n  append:\n    - command: x\n      valu_flags: [-a]\n": "unknown key \"valu_flags\" (did you mean \"value_flags\"?)",
+		"locked: [thresholds.delete]\n":                                     "unknown locked key",
+	}
+	for data, want := range cases {
+		path := filepath.Join(tmp, "clash.yaml")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", data, want, err)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package preview
 import (
 	"bufio"
 	"bytes"
+	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"clash/internal/contextinfo"
@@ -17,6 +20,7 @@ const (
 	HintRM         HintKind = "rm"
 	HintFindDelete HintKind = "find-delete"
 	HintGitClean   HintKind = "git-clean"
+	HintModify     HintKind = "modify"
 )
 
 // Hint carries preview parameters.
@@ -24,46 +28,102 @@ type Hint struct {
 	Kind    HintKind
 	Args    []string
 	Targets []string
+	// Recursive counts everything beneath directory targets (rm -r, chmod -R).
+	Recursive bool
 }
 
+// MaxCount bounds how many paths a recursive preview counts when the caller
+// gives no smaller limit.
+const MaxCount = 100000
+
 // Result holds preview counts and samples.
 type Result struct {
 	Count  int
 	Sample []string
 	Note   string
 	Err    string
+	// Truncated is set when counting stopped early; Count is then a lower
+	// bound.
+	Truncated bool
 }
 
-// Run executes a preview based on the provided hint.
-func Run(hint Hint, ctx contextinfo.Info, sampleLimit int) Result {
+// Run executes a preview based on the provided hint. Recursive walks stop
+// once more than countLimit paths have been seen (MaxCount if countLimit is
+// not positive or larger), so huge trees do not hold up the prompt.
+func Run(hint Hint, ctx contextinfo.Info, sampleLimit, countLimit int) Result {
+	if countLimit <= 0 || countLimit > MaxCount {
+		countLimit = MaxCount
+	}
 	switch hint.Kind {
 	case HintRM:
-		return previewRm(hint, ctx, sampleLimit)
+		return previewRm(hint, ctx, sampleLimit, countLimit)
 	case HintFindDelete:
 		return previewFindDelete(hint, ctx, sampleLimit)
 	case HintGitClean:
 		return previewGitClean(hint, ctx, sampleLimit)
+	case HintModify:
+		res := previewRm(hint, ctx, sampleLimit, countLimit)
+		res.Note = "paths whose mode or owner would change"
+		if res.Truncated {
+			res.Note += truncatedNote(res.Count)
+		}
+		return res
 	default:
 		return Result{Err: "no preview available"}
 	}
 }
 
-func previewRm(hint Hint, ctx contextinfo.Info, sampleLimit int) Result {
+func previewRm(hint Hint, ctx contextinfo.Info, sampleLimit, countLimit int) Result {
 	sample := []string{}
 	count := 0
+	truncated := false
 	for _, t := range hint.Targets {
+		if count > countLimit {
+			truncated = true
+			break
+		}
 		resolved, err := contextinfo.ResolvePath(ctx.Cwd, t)
 		if err != nil {
 			continue
 		}
-		if _, err := os.Stat(resolved); err == nil {
+		info, err := os.Lstat(resolved)
+		if err != nil {
+			continue
+		}
+		if !hint.Recursive || !info.IsDir() {
 			count++
 			if len(sample) < sampleLimit {
 				sample = append(sample, resolved)
 			}
+			continue
 		}
+		filepath.WalkDir(resolved, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if count > countLimit {
+				truncated = true
+				return fs.SkipAll
+			}
+			count++
+			if len(sample) < sampleLimit {
+				sample = append(sample, path)
+			}
+			return nil
+		})
 	}
-	return Result{Count: count, Sample: sample, Note: "targets resolved from provided arguments"}
+	note := "targets resolved from provided arguments"
+	if hint.Recursive {
+		note = "targets and everything beneath them"
+	}
+	if truncated {
+		note += truncatedNote(count)
+	}
+	return Result{Count: count, Sample: sample, Note: note, Truncated: truncated}
+}
+
+func truncatedNote(count int) string {
+	return fmt.Sprintf("; stopped counting after %d", count)
 }
 
 func previewFindDelete(hint Hint, ctx contextinfo.Info, sampleLimit int) Result {
@@ -0,0 +1,295 @@
+// Package replay re-runs audit log entries through the classifier with a
+// candidate policy to show which decisions it would change.
+package replay
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"clash/internal/audit"
+	"clash/internal/classifier"
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+	"clash/internal/preview"
+)
+
+// maxExamples bounds the commands listed under each cause in a report.
+const maxExamples = 10
+
+// Resolver returns the policy that applies to a recorded command.
+type Resolver func(repoRoot, cwd string) (policy.Policy, error)
+
+// Change is a recorded decision the policy would now make differently.
+type Change struct {
+	Entry audit.Entry
+	Argv  []string
+	// Old and New are decisions with " (hard)" appended to hard blocks.
+	Old, New string
+	// Cause names the rule or reason behind the difference.
+	Cause  string
+	Result classifier.Result
+}
+
+// Tightened reports whether the new decision is stricter than the old.
+func (c Change) Tightened() bool {
+	return rank(c.New) > rank(c.Old)
+}
+
+// Report is the outcome of a replay.
+type Report struct {
+	// Policy describes the candidate policy, e.g. its path.
+	Policy   string
+	Total    int
+	Replayed int
+	// Skipped counts entries without a command or a decision, or whose
+	// policy could not be loaded.
+	Skipped int
+	// Legacy counts entries that predate recorded argv, whose argv was split
+	// from the command text and in_repo guessed from the repo root.
+	Legacy  int
+	Changes []Change
+	// Errors lists repo roots whose policy could not be loaded.
+	Errors []string
+}
+
+// Run replays entries, oldest first, under the policies returned by resolve.
+// Recorded preview counts are reused so that threshold changes show up; the
+// previewed files are not looked at again.
+func Run(entries []audit.Entry, resolve Resolver) Report {
+	var rep Report
+	type key struct{ repo, cwd string }
+	policies := map[key]*policy.Policy{}
+	failed := map[string]bool{}
+	for _, e := range entries {
+		rep.Total++
+		argv := e.Argv
+		ctx := contextinfo.Info{Cwd: e.Cwd, RepoRoot: e.RepoRoot, InRepo: e.InRepo, Git: e.Git}
+		if len(argv) == 0 {
+			argv = strings.Fields(e.Command)
+			if len(argv) > 0 {
+				rep.Legacy++
+				_, err := os.Stat(filepath.Join(e.RepoRoot, ".git"))
+				ctx.InRepo = e.RepoRoot != "" && err == nil
+			}
+		}
+		if len(argv) == 0 || e.Decision == "" {
+			rep.Skipped++
+			continue
+		}
+		k := key{e.RepoRoot, e.Cwd}
+		p, ok := policies[k]
+		if !ok {
+			pol, err := resolve(e.RepoRoot, e.Cwd)
+			if err != nil {
+				if !failed[e.RepoRoot] {
+					failed[e.RepoRoot] = true
+					rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", e.RepoRoot, err))
+				}
+			} else {
+				p = &pol
+			}
+			policies[k] = p
+		}
+		if p == nil {
+			rep.Skipped++
+			continue
+		}
+		rep.Replayed++
+		res := evaluate(argv, ctx, *p, e)
+		old := label(classifier.DecisionType(e.Decision), e.Hard)
+		now := label(res.Decision, res.Hard)
+		if old == now {
+			continue
+		}
+		rep.Changes = append(rep.Changes, Change{Entry: e, Argv: argv, Old: old, New: now, Cause: cause(e, res, rank(now) > rank(old)), Result: res})
+	}
+	return rep
+}
+
+// evaluate classifies argv the way the runner does, using the entry's
+// recorded preview in place of running a new one.
+func evaluate(argv []string, ctx contextinfo.Info, p policy.Policy, e audit.Entry) classifier.Result {
+	res := classifier.Evaluate(argv, ctx, p)
+	if res.PreviewHint != nil && e.Preview != nil {
+		hint := *res.PreviewHint
+		pr := preview.Result{Count: e.Preview.Count, Sample: e.Preview.Sample, Note: e.Preview.Note, Err: e.Preview.Err, Truncated: e.Preview.Truncated}
+		res = classifier.ApplyPreviewRules(res, hint, pr, ctx, p)
+		res, _ = classifier.ApplyThresholds(res, hint, pr, p.Thresholds)
+	}
+	return res
+}
+
+// cause names what changed the decision: a rule that now matches or no
+// longer does, or else the first reason of the stricter side.
+func cause(e audit.Entry, res classifier.Result, tightened bool) string {
+	for _, id := range res.Rules {
+		if !contains(e.Rules, id) {
+			return "rule " + id
+		}
+	}
+	for _, id := range e.Rules {
+		if !contains(res.Rules, id) {
+			return "rule " + id + " (no longer matches)"
+		}
+	}
+	if tightened && len(res.Reasons) > 0 {
+		return res.Reasons[0]
+	}
+	if !tightened && len(e.Reasons) > 0 {
+		return e.Reasons[0] + " (no longer applies)"
+	}
+	return "other"
+}
+
+func label(d classifier.DecisionType, hard bool) string {
+	if d == classifier.DecisionBlock && hard {
+		return string(d) + " (hard)"
+	}
+	return string(d)
+}
+
+func rank(label string) int {
+	switch label {
+	case "BLOCK (hard)":
+		return 3
+	case string(classifier.DecisionBlock):
+		return 2
+	case string(classifier.DecisionConfirm):
+		return 1
+	}
+	return 0
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	if strings.HasSuffix(noun, "y") {
+		return fmt.Sprintf("%d %sies", n, strings.TrimSuffix(noun, "y"))
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Markdown writes the report as Markdown for a pull request description: a
+// count per transition, then the changed commands grouped by cause.
+func (r Report) Markdown(w io.Writer) {
+	title := "Policy replay"
+	if r.Policy != "" {
+		title += ": " + r.Policy
+	}
+	fmt.Fprintf(w, "## %s\n\n", title)
+	fmt.Fprintf(w, "Replayed %d of %d audit entries", r.Replayed, r.Total)
+	if r.Skipped > 0 {
+		fmt.Fprintf(w, " (%d skipped)", r.Skipped)
+	}
+	fmt.Fprintf(w, "; %s would change", plural(len(r.Changes), "decision"))
+	tightened := 0
+	for _, c := range r.Changes {
+		if c.Tightened() {
+			tightened++
+		}
+	}
+	if len(r.Changes) > 0 {
+		fmt.Fprintf(w, " (%d stricter, %d looser)", tightened, len(r.Changes)-tightened)
+	}
+	fmt.Fprintln(w, ".")
+	if r.Legacy > 0 {
+		fmt.Fprintf(w, "\nArgv was not recorded for %s; their commands were split on spaces.\n", plural(r.Legacy, "entry"))
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "\nCould not load policy for %s\n", e)
+	}
+	if len(r.Changes) == 0 {
+		return
+	}
+
+	transitions := map[string]int{}
+	var order []string
+	for _, c := range r.Changes {
+		t := c.Old + " → " + c.New
+		if transitions[t] == 0 {
+			order = append(order, t)
+		}
+		transitions[t]++
+	}
+	sort.SliceStable(order, func(i, j int) bool { return transitions[order[i]] > transitions[order[j]] })
+	fmt.Fprintln(w, "\n| Change | Count |")
+	fmt.Fprintln(w, "| --- | ---: |")
+	for _, t := range order {
+		fmt.Fprintf(w, "| %s | %d |\n", t, transitions[t])
+	}
+
+	for _, g := range groups(r.Changes) {
+		fmt.Fprintf(w, "\n### %s (%d)\n\n", g.cause, g.count)
+		for i, ex := range g.examples {
+			if i == maxExamples {
+				fmt.Fprintf(w, "- … and %d more\n", len(g.examples)-maxExamples)
+				break
+			}
+			times := ""
+			if ex.count > 1 {
+				times = fmt.Sprintf(" ×%d", ex.count)
+			}
+			fmt.Fprintf(w, "- `%s`%s: %s\n", ex.command, times, ex.transition)
+		}
+	}
+}
+
+type group struct {
+	cause    string
+	count    int
+	examples []example
+}
+
+type example struct {
+	command, transition string
+	count               int
+}
+
+// groups collects changes by cause, largest first, merging repeats of the
+// same command and transition.
+func groups(changes []Change) []group {
+	var out []group
+	index := map[string]int{}
+	for _, c := range changes {
+		i, ok := index[c.Cause]
+		if !ok {
+			i = len(out)
+			index[c.Cause] = i
+			out = append(out, group{cause: c.Cause})
+		}
+		g := &out[i]
+		g.count++
+		cmd := strings.Join(c.Argv, " ")
+		t := c.Old + " → " + c.New
+		found := false
+		for j := range g.examples {
+			if g.examples[j].command == cmd && g.examples[j].transition == t {
+				g.examples[j].count++
+				found = true
+				break
+			}
+		}
+		if !found {
+			g.examples = append(g.examples, example{command: cmd, transition: t, count: 1})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].count > out[j].count })
+	for _, g := range out {
+		sort.SliceStable(g.examples, func(i, j int) bool { return g.examples[i].count > g.examples[j].count })
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package replay
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clash/internal/audit"
+	"clash/internal/policy"
+)
+
+func TestReplayReportsChangedDecisions(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "new.yaml")
+	data := `rules:
+  - id: no-force-push
+    match: {command: git, subcommand: push, flags_present: [--force]}
+    action: block
+  - id: make-ok
+    match: {command: make}
+    action: allow
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	candidate, err := policy.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := func(decision string, argv ...string) audit.Entry {
+		return audit.Entry{Argv: argv, Command: strings.Join(argv, " "), Cwd: tmp, RepoRoot: tmp, InRepo: true, Decision: decision}
+	}
+	entries := []audit.Entry{
+		entry("CONFIRM", "git", "push", "--force", "origin", "main"),
+		entry("CONFIRM", "git", "push", "--force", "origin", "main"),
+		entry("CONFIRM", "make", "clean"),
+		entry("ALLOW", "ls"),
+		{Command: "git push --force origin dev", Cwd: tmp, RepoRoot: tmp, Decision: "CONFIRM"},
+		{Cwd: tmp, RepoRoot: tmp},
+	}
+	rep := Run(entries, func(repoRoot, cwd string) (policy.Policy, error) {
+		if repoRoot != tmp || cwd != tmp {
+			t.Errorf("unexpected resolve(%q, %q)", repoRoot, cwd)
+		}
+		return candidate, nil
+	})
+	if rep.Total != 6 || rep.Replayed != 5 || rep.Skipped != 1 || rep.Legacy != 1 {
+		t.Errorf("unexpected counts: %+v", rep)
+	}
+	if len(rep.Changes) != 4 {
+		t.Fatalf("expected 4 changes, got %d: %+v", len(rep.Changes), rep.Changes)
+	}
+	for _, c := range rep.Changes {
+		want := "rule no-force-push"
+		if c.Argv[0] == "make" {
+			want = "rule make-ok"
+		}
+		if c.Cause != want {
+			t.Errorf("%v: expected cause %q, got %q", c.Argv, want, c.Cause)
+		}
+	}
+
+	var buf bytes.Buffer
+	rep.Policy = "new.yaml"
+	rep.Markdown(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"## Policy replay: new.yaml",
+		"4 decisions would change (3 stricter, 1 looser)",
+		"| CONFIRM → BLOCK | 3 |",
+		"### rule no-force-push (3)",
+		"- `git push --force origin main` ×2: CONFIRM → BLOCK",
+		"### rule make-ok (1)",
+		"Argv was not recorded for 1 entry",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestReplayUsesRecordedPreview(t *testing.T) {
+	tmp := t.TempDir()
+	pol, err := policy.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol.Thresholds.DeleteCount = 10
+	pol.Thresholds.ExceededAction = "block"
+	e := audit.Entry{
+		Argv: []string{"rm", "-r", "build"}, Cwd: tmp, RepoRoot: tmp, InRepo: true,
+		Decision: "CONFIRM", Preview: &audit.PreviewRecord{Count: 50},
+	}
+	rep := Run([]audit.Entry{e}, func(string, string) (policy.Policy, error) { return pol, nil })
+	if len(rep.Changes) != 1 || rep.Changes[0].New != "BLOCK" {
+		t.Fatalf("expected the lower threshold to block, got %+v", rep.Changes)
+	}
+}
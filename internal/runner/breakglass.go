@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"clash/internal/audit"
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+// breakGlassPhrase must be typed to confirm an override.
+const breakGlassPhrase = "break glass for clash"
+
+// checkBreakGlass enforces the policy's preconditions for a break-glass
+// override: a clean tree when required, a non-trivial reason, and the
+// cooldown and daily quota counted from prior overrides in the audit log.
+func checkBreakGlass(reason string, pol policy.Policy, ctx contextinfo.Info, history []audit.Entry, now time.Time) error {
+	if pol.Options.RequireCleanTreeForBreakGlass && (ctx.Git.Changed > 0 || ctx.Git.Untracked > 0) {
+		return fmt.Errorf("break-glass requires a clean working tree (%d changed, %d untracked)", ctx.Git.Changed, ctx.Git.Untracked)
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return fmt.Errorf("break-glass requires --break-glass-reason")
+	}
+	if minLen := pol.BreakGlass.MinReasonLength; len(reason) < minLen {
+		return fmt.Errorf("break-glass reason must be at least %d characters", minLen)
+	}
+
+	var cooldown time.Duration
+	if pol.BreakGlass.Cooldown != "" {
+		d, err := time.ParseDuration(pol.BreakGlass.Cooldown)
+		if err != nil {
+			return fmt.Errorf("invalid break_glass.cooldown %q: %w", pol.BreakGlass.Cooldown, err)
+		}
+		cooldown = d
+	}
+	year, month, day := now.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	var last time.Time
+	today := 0
+	for _, e := range history {
+		if !e.BreakGlass {
+			continue
+		}
+		if e.Timestamp.After(last) {
+			last = e.Timestamp
+		}
+		if !e.Timestamp.Before(midnight) {
+			today++
+		}
+	}
+	if cooldown > 0 && !last.IsZero() && now.Sub(last) < cooldown {
+		wait := cooldown - now.Sub(last)
+		return fmt.Errorf("break-glass cooldown: try again in %s", wait.Round(time.Second))
+	}
+	if quota := pol.BreakGlass.Quota(); quota > 0 && today >= quota {
+		return fmt.Errorf("break-glass daily quota of %d reached", quota)
+	}
+	return nil
+}
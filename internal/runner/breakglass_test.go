@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"clash/internal/audit"
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+func TestCheckBreakGlass(t *testing.T) {
+	zone := time.FixedZone("UTC+5", 5*60*60)
+	now := time.Date(2026, 3, 10, 0, 30, 0, 0, zone)
+	quota := 2
+	pol := policy.Policy{BreakGlass: policy.BreakGlassConfig{MinReasonLength: 12, Cooldown: "5m", DailyQuota: &quota}}
+	strict := pol
+	strict.Options.RequireCleanTreeForBreakGlass = true
+	noCooldown := pol
+	noCooldown.BreakGlass.Cooldown = ""
+	badCooldown := pol
+	badCooldown.BreakGlass.Cooldown = "5 minutes"
+	unlimited := noCooldown
+	unlimited.BreakGlass.DailyQuota = new(int)
+
+	override := func(at time.Time) audit.Entry {
+		return audit.Entry{Timestamp: at, BreakGlass: true}
+	}
+	reason := "hotfix for prod outage"
+	dirty := contextinfo.Info{Git: contextinfo.GitSummary{Untracked: 1}}
+
+	cases := []struct {
+		name    string
+		reason  string
+		pol     policy.Policy
+		ctx     contextinfo.Info
+		history []audit.Entry
+		want    string
+	}{
+		{name: "clean tree not required", reason: reason, pol: pol, ctx: dirty},
+		{name: "dirty tree refused", reason: reason, pol: strict, ctx: dirty, want: "clean working tree"},
+		{name: "missing reason", reason: "   ", pol: pol, want: "requires --break-glass-reason"},
+		{name: "reason too short once trimmed", reason: "    fix prod    ", pol: pol, want: "at least 12 characters"},
+		{name: "reason long enough", reason: "  fix prod now  ", pol: pol},
+		{name: "cooldown elapsed", reason: reason, pol: pol, history: []audit.Entry{override(now.Add(-5 * time.Minute))}},
+		{name: "within cooldown", reason: reason, pol: pol, history: []audit.Entry{override(now.Add(-5*time.Minute + time.Second))}, want: "try again in 1s"},
+		{name: "other entries ignored", reason: reason, pol: pol, history: []audit.Entry{{Timestamp: now.Add(-time.Minute)}}},
+		{name: "invalid cooldown", reason: reason, pol: badCooldown, want: "invalid break_glass.cooldown"},
+		{
+			name: "quota counts from local midnight", reason: reason, pol: noCooldown,
+			history: []audit.Entry{override(now.Add(-40 * time.Minute)), override(now.Add(-35 * time.Minute)), override(now.Add(-20 * time.Minute).UTC())},
+		},
+		{
+			name: "quota reached", reason: reason, pol: noCooldown,
+			history: []audit.Entry{override(now.Add(-20 * time.Minute).UTC()), override(now.Add(-10 * time.Minute))},
+			want:    "daily quota of 2 reached",
+		},
+		{
+			name: "zero quota is unlimited", reason: reason, pol: unlimited,
+			history: []audit.Entry{override(now.Add(-20 * time.Minute)), override(now.Add(-10 * time.Minute))},
+		},
+	}
+	for _, c := range cases {
+		err := checkBreakGlass(c.reason, c.pol, c.ctx, c.history, now)
+		switch {
+		case c.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.want, err)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -34,18 +33,14 @@ func Run(args []string, opts RunOptions) (int, error) {
 		return 1, err
 	}
 
-	policyPath := opts.PolicyPath
-	if policyPath == "" {
-		candidate := filepath.Join(ctx.RepoRoot, "clash.yaml")
-		if _, err := os.Stat(candidate); err == nil {
-			policyPath = candidate
-		}
-	}
-
-	pol, err := policy.Load(policyPath)
+	resolution, err := policy.Resolve(ctx.RepoRoot, ctx.Cwd, opts.PolicyPath)
 	if err != nil {
 		return 1, err
 	}
+	for _, w := range resolution.Warnings {
+		fmt.Fprintln(os.Stderr, "CLASH: policy:", w)
+	}
+	pol := resolution.Policy
 
 	result := classifier.Evaluate(args, ctx, pol)
 	logger, err := audit.New(ctx.RepoRoot)
@@ -70,23 +65,49 @@ func Run(args []string, opts RunOptions) (int, error) {
 		ID:        uuid.New().String(),
 		Timestamp: time.Now().UTC(),
 		Command:   strings.Join(args, " "),
+		Argv:      args,
+		RequestedBinary: result.Executable.Requested,
+		ResolvedBinary:  result.Executable.Resolved,
 		Cwd:       ctx.Cwd,
 		RepoRoot:  ctx.RepoRoot,
+		InRepo:    ctx.InRepo,
 		Git:       ctx.Git,
 		Decision:  string(result.Decision),
 		Hard:      result.Hard,
 		Signals:   result.Signals,
 		Reasons:   result.Reasons,
 		SaferAlternative: result.SaferAlternative,
+		Rules:     result.Rules,
+	}
+	for _, sc := range result.Scripts {
+		rec := audit.ScriptRecord{Path: sc.Path, SHA256: sc.SHA256}
+		for _, f := range sc.Findings {
+			rec.Findings = append(rec.Findings, audit.ScriptFinding{Line: f.Line, Text: f.Text, Decision: string(f.Decision), Signals: f.Signals, Reasons: f.Reasons})
+		}
+		auditEntry.Scripts = append(auditEntry.Scripts, rec)
 	}
 
 	var previewRes *preview.Result
+	var threshold *classifier.ThresholdHit
 	if result.PreviewHint != nil {
-		pr := preview.Run(*result.PreviewHint, ctx, pol.Thresholds.PreviewSample)
+		hint := *result.PreviewHint
+		// Counting past the largest threshold cannot change the decision.
+		pr := preview.Run(hint, ctx, pol.Thresholds.PreviewSample, max(pol.Thresholds.DeleteCount, pol.Thresholds.ModifyCount))
 		previewRes = &pr
 		if auditEntry.Preview == nil {
-			auditEntry.Preview = &audit.PreviewRecord{Count: pr.Count, Sample: pr.Sample, Note: pr.Note, Err: pr.Err}
+			auditEntry.Preview = &audit.PreviewRecord{Count: pr.Count, Sample: pr.Sample, Note: pr.Note, Err: pr.Err, Truncated: pr.Truncated}
 		}
+		result = classifier.ApplyPreviewRules(result, hint, pr, ctx, pol)
+		result, threshold = classifier.ApplyThresholds(result, hint, pr, pol.Thresholds)
+		if threshold != nil {
+			auditEntry.Threshold = &audit.ThresholdRecord{Name: threshold.Name, Limit: threshold.Limit, Count: threshold.Count, Action: threshold.Action}
+		}
+		auditEntry.Decision = string(result.Decision)
+		auditEntry.Hard = result.Hard
+		auditEntry.Signals = result.Signals
+		auditEntry.Reasons = result.Reasons
+		auditEntry.Rules = result.Rules
+		auditEntry.SaferAlternative = result.SaferAlternative
 	}
 
 	switch result.Decision {
@@ -102,6 +123,13 @@ func Run(args []string, opts RunOptions) (int, error) {
 		if result.SaferAlternative != "" {
 			fmt.Println("Safer:", result.SaferAlternative)
 		}
+		if opts.BreakGlass && !result.Hard {
+			// Soft blocks may be overridden below.
+			break
+		}
+		if opts.BreakGlass {
+			fmt.Println("Break-glass is not allowed on hard blocks.")
+		}
 		auditEntry.Outcome = "blocked"
 		logger.Record(auditEntry)
 		return 1, fmt.Errorf("command blocked")
@@ -112,7 +140,11 @@ func Run(args []string, opts RunOptions) (int, error) {
 			fmt.Println("- signal:", s)
 		}
 		if previewRes != nil {
-			fmt.Printf("Preview: %d items", previewRes.Count)
+			if previewRes.Truncated {
+				fmt.Printf("Preview: more than %d items", previewRes.Count-1)
+			} else {
+				fmt.Printf("Preview: %d items", previewRes.Count)
+			}
 			if len(previewRes.Sample) > 0 {
 				fmt.Printf(" sample: %s", strings.Join(previewRes.Sample, ", "))
 			}
@@ -126,7 +158,12 @@ func Run(args []string, opts RunOptions) (int, error) {
 		if approved {
 			approver = "--yes"
 		}
-		if !approved {
+		if threshold != nil {
+			// Exceeding a threshold needs a typed phrase; --yes is not enough.
+			fmt.Printf("Preview exceeds %s (%d > %d).\n", threshold.Name, threshold.Count, threshold.Limit)
+			approved = ui.RequirePhrase("Typed confirmation required.", threshold.Phrase())
+			approver = "typed"
+		} else if !approved {
 			approved = ui.Confirm("Proceed with execution?")
 			if approved {
 				approver = "user"
@@ -144,9 +181,22 @@ func Run(args []string, opts RunOptions) (int, error) {
 		fmt.Println("CLASH: ALLOW (fast path)")
 	}
 
-	if opts.BreakGlass {
-		phrase := "break glass for clash"
-		if !ui.RequirePhrase("Break-glass override requested.", phrase) {
+	if opts.BreakGlass && result.Decision != classifier.DecisionAllow {
+		history, err := logger.Entries()
+		if err != nil {
+			return 1, fmt.Errorf("read audit log: %w", err)
+		}
+		if err := checkBreakGlass(opts.BreakGlassReason, pol, ctx, history, time.Now()); err != nil {
+			fmt.Println("Break-glass refused:", err)
+			auditEntry.Outcome = "cancelled"
+			if result.Decision == classifier.DecisionBlock {
+				auditEntry.Outcome = "blocked"
+			}
+			auditEntry.Error = err.Error()
+			logger.Record(auditEntry)
+			return 1, err
+		}
+		if !ui.RequirePhrase("Break-glass override requested.", breakGlassPhrase) {
 			fmt.Println("Break-glass phrase mismatch; aborting.")
 			auditEntry.Outcome = "cancelled"
 			logger.Record(auditEntry)
@@ -171,6 +221,7 @@ func Run(args []string, opts RunOptions) (int, error) {
 func execute(args []string, ctx contextinfo.Info) (int, error) {
 	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Dir = ctx.Cwd
+	cmd.Env = ctx.Env
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
@@ -0,0 +1,378 @@
+// Package suggest mines the audit log for policy changes: commands that are
+// always approved, commands that are usually cancelled and allowlist entries
+// that are never used.
+package suggest
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"clash/internal/audit"
+	"clash/internal/classifier"
+	"clash/internal/policy"
+)
+
+// Options tune what counts as a pattern.
+type Options struct {
+	// MinCount is how many times a command must be seen before anything is
+	// suggested for it.
+	MinCount int
+	// Examples is how many example commands justify each suggestion.
+	Examples int
+}
+
+// DefaultOptions are used for zero fields.
+var DefaultOptions = Options{MinCount: 5, Examples: 3}
+
+// cancelRatio is the share of cancellations above which a command is
+// suggested for blocking.
+const cancelRatio = 0.8
+
+// Suggestion is one proposed policy edit.
+type Suggestion struct {
+	// Key is the policy key edited (allow_commands, rules, protected_paths).
+	Key string
+	// Directive is policy.DirectiveAppend or policy.DirectiveRemove.
+	Directive string
+	// Value is a string entry or a policy.Rule.
+	Value interface{}
+	// Why gives the counts and examples behind the suggestion.
+	Why string
+}
+
+// Report holds the suggestions drawn from a log.
+type Report struct {
+	Entries     int
+	From, To    time.Time
+	Suggestions []Suggestion
+}
+
+type group struct {
+	name, subcommand    string
+	approved, cancelled int
+	inRepo              bool
+	// risky is set once a prompt in the group carried a signal other than
+	// "mutating command"; such groups are never suggested for allowing.
+	risky bool
+	// commands are the distinct approved commands an allow rule is scoped to.
+	commands                []string
+	approvedEx, cancelledEx []string
+}
+
+// prompt is a CONFIRM the user answered.
+type prompt struct {
+	entry    audit.Entry
+	command  string
+	facts    classifier.CommandFacts
+	approved bool
+}
+
+// Analyze looks for patterns in entries under the current policy p. Entries
+// of the allowlist that are also in defaults are never suggested for removal.
+func Analyze(entries []audit.Entry, p, defaults policy.Policy, opts Options) Report {
+	if opts.MinCount <= 0 {
+		opts.MinCount = DefaultOptions.MinCount
+	}
+	if opts.Examples <= 0 {
+		opts.Examples = DefaultOptions.Examples
+	}
+	rep := Report{Entries: len(entries)}
+	groups := map[string]*group{}
+	var order []string
+	protected := map[string]int{}
+	protectedEx := map[string][]string{}
+	used := map[string]bool{}
+	var prompts []prompt
+	seen := map[string]int{}
+
+	for _, e := range entries {
+		if rep.From.IsZero() || e.Timestamp.Before(rep.From) {
+			rep.From = e.Timestamp
+		}
+		if e.Timestamp.After(rep.To) {
+			rep.To = e.Timestamp
+		}
+		argv := e.Argv
+		if len(argv) == 0 {
+			argv = strings.Fields(e.Command)
+		}
+		if len(argv) == 0 {
+			continue
+		}
+		argv = append([]string{filepath.Base(argv[0])}, argv[1:]...)
+		command := strings.Join(argv, " ")
+		for _, a := range p.AllowCommands {
+			if strings.EqualFold(command, a) || strings.HasPrefix(strings.ToLower(command), strings.ToLower(a)+" ") {
+				used[a] = true
+			}
+		}
+		if e.Decision != string(classifier.DecisionConfirm) || e.BreakGlass {
+			continue
+		}
+		approved := e.ApprovedBy == "user" || e.ApprovedBy == "typed"
+		if !approved && e.Outcome != "cancelled" {
+			continue
+		}
+		facts := classifier.DescribeCommand(argv, p)
+		prompts = append(prompts, prompt{entry: e, command: command, facts: facts, approved: approved})
+		if subcommandWord.MatchString(facts.Subcommand) {
+			seen[facts.Name+" "+facts.Subcommand]++
+		}
+	}
+
+	for _, pr := range prompts {
+		// The first word is a subcommand (git push, npm run) only when it
+		// repeats; a one-off is an operand (mv a b) and groups by name.
+		sub := pr.facts.Subcommand
+		if seen[pr.facts.Name+" "+sub] < 2 {
+			sub = ""
+		}
+		key := pr.facts.Name + " " + sub
+		g, ok := groups[key]
+		if !ok {
+			g = &group{name: pr.facts.Name, subcommand: sub, inRepo: true}
+			groups[key] = g
+			order = append(order, key)
+		}
+		if !pr.entry.InRepo && len(pr.entry.Argv) > 0 {
+			g.inRepo = false
+		}
+		for _, sig := range pr.entry.Signals {
+			if sig != "mutating command" {
+				g.risky = true
+			}
+		}
+		if pr.approved {
+			g.approved++
+			if !contains(g.commands, pr.command) {
+				g.commands = append(g.commands, pr.command)
+			}
+			g.approvedEx = addExample(g.approvedEx, pr.command, opts.Examples)
+		} else {
+			g.cancelled++
+			g.cancelledEx = addExample(g.cancelledEx, pr.command, opts.Examples)
+			for _, dir := range topDirs(pr.facts.Writes, pr.entry) {
+				protected[dir]++
+				protectedEx[dir] = addExample(protectedEx[dir], pr.command, opts.Examples)
+			}
+		}
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		total := g.approved + g.cancelled
+		switch {
+		case g.approved >= opts.MinCount && g.cancelled == 0 && !g.risky:
+			// allow_commands entries match by prefix and skip the force and
+			// protected path checks, so the rule only allows the exact
+			// commands that were approved.
+			sort.Strings(g.commands)
+			exact := make([]string, len(g.commands))
+			for i, c := range g.commands {
+				exact[i] = regexp.QuoteMeta(c)
+			}
+			rule := policy.Rule{ID: ruleID("allow", g), Action: policy.RuleAllow, Reason: "always approved"}
+			rule.Match.Command = policy.StringList{g.name}
+			rule.Match.Argv = "^(?:" + strings.Join(exact, "|") + ")$"
+			if g.inRepo {
+				yes := true
+				rule.Match.InRepo = &yes
+			}
+			rep.Suggestions = append(rep.Suggestions, Suggestion{
+				Key:       "rules",
+				Directive: policy.DirectiveAppend,
+				Value:     rule,
+				Why:       fmt.Sprintf("approved %s, never cancelled; e.g. %s", times(g.approved), quote(g.approvedEx)),
+			})
+		case g.cancelled >= opts.MinCount && float64(g.cancelled) >= cancelRatio*float64(total):
+			rule := policy.Rule{ID: ruleID("block", g), Action: policy.RuleBlock, Reason: fmt.Sprintf("cancelled %d of %d times", g.cancelled, total)}
+			rule.Match.Command = policy.StringList{g.name}
+			if g.subcommand != "" {
+				rule.Match.Subcommand = policy.StringList{g.subcommand}
+			}
+			rep.Suggestions = append(rep.Suggestions, Suggestion{
+				Key:       "rules",
+				Directive: policy.DirectiveAppend,
+				Value:     rule,
+				Why:       fmt.Sprintf("cancelled %d of %d times; e.g. %s", g.cancelled, total, quote(g.cancelledEx)),
+			})
+		}
+	}
+
+	var dirs []string
+	for dir, n := range protected {
+		if n >= opts.MinCount && !isProtected(p, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if protected[dirs[i]] != protected[dirs[j]] {
+			return protected[dirs[i]] > protected[dirs[j]]
+		}
+		return dirs[i] < dirs[j]
+	})
+	for _, dir := range dirs {
+		rep.Suggestions = append(rep.Suggestions, Suggestion{
+			Key:       "protected_paths",
+			Directive: policy.DirectiveAppend,
+			Value:     dir,
+			Why:       fmt.Sprintf("written by %s that were cancelled; e.g. %s", plural(protected[dir], "command", "commands"), quote(protectedEx[dir])),
+		})
+	}
+
+	var unused []string
+	for _, a := range p.AllowCommands {
+		if !used[a] && !containsFold(defaults.AllowCommands, a) {
+			unused = append(unused, a)
+		}
+	}
+	if len(entries) > 0 {
+		for _, a := range unused {
+			rep.Suggestions = append(rep.Suggestions, Suggestion{
+				Key:       "allow_commands",
+				Directive: policy.DirectiveRemove,
+				Value:     a,
+				Why:       fmt.Sprintf("allowed but never run in %s", plural(len(entries), "logged command", "logged commands")),
+			})
+		}
+	}
+	return rep
+}
+
+// topDirs returns the repo-relative top-level directories of the written
+// targets of a recorded command.
+func topDirs(writes []string, e audit.Entry) []string {
+	var dirs []string
+	for _, w := range writes {
+		path := w
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(e.Cwd, path)
+		}
+		rel, err := filepath.Rel(e.RepoRoot, filepath.Clean(path))
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		top := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		if !contains(dirs, top) {
+			dirs = append(dirs, top)
+		}
+	}
+	return dirs
+}
+
+func isProtected(p policy.Policy, dir string) bool {
+	for _, pp := range p.ProtectedPaths {
+		if strings.TrimSuffix(pp.Path, "/") == dir {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	nonID          = regexp.MustCompile(`[^a-z0-9]+`)
+	subcommandWord = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+)
+
+func ruleID(action string, g *group) string {
+	id := strings.Trim(nonID.ReplaceAllString(strings.ToLower(g.name+" "+g.subcommand), "-"), "-")
+	return "suggested-" + action + "-" + id
+}
+
+func addExample(list []string, command string, limit int) []string {
+	if len(list) >= limit || contains(list, command) {
+		return list
+	}
+	return append(list, command)
+}
+
+func quote(examples []string) string {
+	out := make([]string, len(examples))
+	for i, e := range examples {
+		out[i] = "`" + e + "`"
+	}
+	return strings.Join(out, ", ")
+}
+
+func times(n int) string {
+	if n == 1 {
+		return "once"
+	}
+	return fmt.Sprintf("%d times", n)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// YAML renders the suggestions as a policy layer made of append and remove
+// directives, each entry preceded by a comment saying why.
+func (r Report) YAML() (string, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	root.HeadComment = "Suggested by clash policy suggest from " + plural(r.Entries, "audit entry", "audit entries")
+	if !r.From.IsZero() {
+		root.HeadComment += fmt.Sprintf(" (%s to %s)", r.From.Format("2006-01-02"), r.To.Format("2006-01-02"))
+	}
+	root.HeadComment += ".\nReview each entry before merging it into clash.yaml."
+	if len(r.Suggestions) == 0 {
+		root.HeadComment += "\nNo suggestions."
+	}
+	sections := map[string]*yaml.Node{}
+	for _, s := range r.Suggestions {
+		section, ok := sections[s.Key]
+		if !ok {
+			section = &yaml.Node{Kind: yaml.MappingNode}
+			sections[s.Key] = section
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.Key}, section)
+		}
+		var list *yaml.Node
+		for i := 0; i+1 < len(section.Content); i += 2 {
+			if section.Content[i].Value == s.Directive {
+				list = section.Content[i+1]
+			}
+		}
+		if list == nil {
+			list = &yaml.Node{Kind: yaml.SequenceNode}
+			section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.Directive}, list)
+		}
+		item := &yaml.Node{}
+		if err := item.Encode(s.Value); err != nil {
+			return "", err
+		}
+		item.HeadComment = s.Why
+		list.Content = append(list.Content, item)
+	}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,101 @@
+package suggest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"clash/internal/audit"
+	"clash/internal/classifier"
+	"clash/internal/contextinfo"
+	"clash/internal/policy"
+)
+
+func TestSuggestFromAuditLog(t *testing.T) {
+	defaults, err := policy.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := defaults
+	p.AllowCommands = append(append([]string{}, defaults.AllowCommands...), "terraform plan", "make lint")
+
+	start := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	var entries []audit.Entry
+	signals := []string{"mutating command"}
+	add := func(n int, decision, approvedBy, outcome string, argv ...string) {
+		for i := 0; i < n; i++ {
+			entries = append(entries, audit.Entry{
+				Timestamp: start.Add(time.Duration(len(entries)) * time.Hour),
+				Argv:      argv, Command: strings.Join(argv, " "),
+				Cwd: "/work/app", RepoRoot: "/work/app", InRepo: true,
+				Decision: decision, ApprovedBy: approvedBy, Outcome: outcome,
+				Signals: signals,
+			})
+		}
+	}
+	add(6, "CONFIRM", "user", "executed", "make", "test")
+	add(3, "CONFIRM", "user", "executed", "npm", "run", "build")
+	add(3, "CONFIRM", "user", "executed", "npm", "run", "lint")
+	add(5, "CONFIRM", "user", "executed", "git", "push")
+	add(5, "CONFIRM", "", "cancelled", "rm", "-rf", "deploy/prod")
+	add(1, "CONFIRM", "user", "executed", "rm", "-rf", "deploy/old")
+	add(9, "CONFIRM", "--yes", "executed", "git", "commit", "-m", "wip")
+	add(4, "ALLOW", "", "executed", "make", "lint")
+	signals = []string{"mutating command", "touches protected path"}
+	add(5, "CONFIRM", "user", "executed", "chmod", "600", "/etc/app.conf")
+
+	rep := Analyze(entries, p, defaults, Options{MinCount: 5})
+	out, err := rep.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Suggested by clash policy suggest from 41 audit entries (2026-09-01 to 2026-09-03).",
+		"# approved 6 times, never cancelled; e.g. `make test`\n        - id: suggested-allow-make-test",
+		"# approved 6 times, never cancelled; e.g. `npm run build`, `npm run lint`",
+		"id: suggested-allow-npm-run",
+		"argv: ^(?:npm run build|npm run lint)$",
+		"argv: ^(?:git push)$",
+		"id: suggested-block-rm",
+		"reason: cancelled 5 of 6 times",
+		"protected_paths:\n    append:\n        # written by 5 commands that were cancelled; e.g. `rm -rf deploy/prod`\n        - deploy",
+		"remove:\n        # allowed but never run in 41 logged commands\n        - terraform plan",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("suggestions missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"git commit", "- make lint", "chmod", "allow_commands:\n    append:"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected suggestion %q:\n%s", unwanted, out)
+		}
+	}
+	if err := policy.Check([]byte(out)); err != nil {
+		t.Fatalf("suggestions are not a valid policy layer: %v\n%s", err, out)
+	}
+
+	// The allow rules cover what was approved and nothing else.
+	path := filepath.Join(t.TempDir(), "clash.yaml")
+	if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := policy.LoadLayers([]policy.Layer{{Name: policy.LayerRepo, Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := contextinfo.Info{Cwd: "/work/app", RepoRoot: "/work/app", InRepo: true}
+	for argv, want := range map[string]bool{
+		"npm run build":                true,
+		"git push":                     true,
+		"npm run deploy":               false,
+		"npm run build --prefix ../..": false,
+		"git push --force origin main": false,
+	} {
+		got := classifier.Evaluate(strings.Fields(argv), ctx, res.Policy)
+		if allowed := len(got.Rules) > 0 && strings.HasPrefix(got.Rules[0], "suggested-allow-"); allowed != want {
+			t.Errorf("%s: expected a suggested allow rule to apply: %v, got %s %v", argv, want, got.Decision, got.Rules)
+		}
+	}
+}